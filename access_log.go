@@ -0,0 +1,314 @@
+package thruster
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	LogFormatJSON     = "json"
+	LogFormatLogfmt   = "logfmt"
+	LogFormatCombined = "combined"
+)
+
+// DefaultAccessLogFields are logged when AccessLog.Fields is empty.
+var DefaultAccessLogFields = []string{
+	"time", "method", "path", "route", "status", "latency", "bytes", "client_ip", "user", "request_id",
+}
+
+type accessLogger struct {
+	mutex  sync.Mutex
+	output string
+	writer io.Writer
+}
+
+func (s *Server) logAccess(c *gin.Context) {
+	config := s.currentConfig().AccessLog
+	if !config.Enabled {
+		return
+	}
+
+	start := time.Now()
+	c.Next()
+
+	if config.excluded(c.Request.URL.Path) || !config.sampled(c.Writer.Status()) {
+		return
+	}
+
+	entry := newAccessLogEntry(c, start)
+	var line []byte
+	switch config.Format {
+	case LogFormatLogfmt:
+		line = entry.logfmt(config.fields())
+	case LogFormatCombined:
+		line = entry.combined()
+	default:
+		line = entry.json(config.fields())
+	}
+
+	if err := s.writeAccessLog(config, line); err != nil {
+		s.logf(LogLevelWarn, "access log: %s", err)
+	}
+}
+
+// defaultLogger is gin's logger, skipped while the access log is enabled,
+// so requests aren't logged twice.
+func (s *Server) defaultLogger() gin.HandlerFunc {
+	logger := gin.Logger()
+	return func(c *gin.Context) {
+		if s.currentConfig().AccessLog.Enabled {
+			return
+		}
+		logger(c)
+	}
+}
+
+// writeAccessLog writes line to the configured output, reopening it when a
+// reload changes it. The writes hold the logger's lock, which keeps the
+// lines from interleaving and the output from being closed mid-write.
+func (s *Server) writeAccessLog(config AccessLog, line []byte) error {
+	s.configMutex.Lock()
+	if s.accessLog == nil {
+		s.accessLog = &accessLogger{}
+	}
+	logger := s.accessLog
+	s.configMutex.Unlock()
+
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+
+	output := fmt.Sprintf("%s:%d:%d", config.Output, config.MaxSize, config.MaxBackups)
+	if logger.writer == nil || logger.output != output {
+		closeLogOutput(logger.writer)
+		logger.writer = nil
+
+		writer, err := openLogOutput(config)
+		if err != nil {
+			return err
+		}
+		logger.output = output
+		logger.writer = writer
+	}
+
+	_, err := logger.writer.Write(line)
+	return err
+}
+
+// closeAccessLog closes the access log output. It's reopened by the next
+// request logged.
+func (s *Server) closeAccessLog() error {
+	s.configMutex.Lock()
+	logger := s.accessLog
+	s.configMutex.Unlock()
+	if logger == nil {
+		return nil
+	}
+
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+
+	writer := logger.writer
+	logger.writer = nil
+	return closeLogOutput(writer)
+}
+
+// closeLogOutput closes the log files, leaving stdout and stderr open.
+func closeLogOutput(writer io.Writer) error {
+	if closer, ok := writer.(io.Closer); ok && closer != os.Stdout && closer != os.Stderr {
+		return closer.Close()
+	}
+	return nil
+}
+
+func openLogOutput(config AccessLog) (io.Writer, error) {
+	switch config.Output {
+	case "", "stdout":
+		return os.Stdout, nil
+	case "stderr":
+		return os.Stderr, nil
+	}
+
+	return newRotatingFile(config.Output, int64(config.MaxSize)*1024*1024, config.MaxBackups)
+}
+
+func (a AccessLog) fields() []string {
+	if len(a.Fields) == 0 {
+		return DefaultAccessLogFields
+	}
+	return a.Fields
+}
+
+func (a AccessLog) excluded(path string) bool {
+	for _, excluded := range a.ExcludePaths {
+		if path == excluded || (strings.HasSuffix(excluded, "*") && strings.HasPrefix(path, strings.TrimSuffix(excluded, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// sampled decides if a request is logged. Server errors are always logged.
+func (a AccessLog) sampled(status int) bool {
+	if a.SampleRate <= 0 || a.SampleRate >= 1 || status >= 500 {
+		return true
+	}
+	return rand.Float64() < a.SampleRate
+}
+
+type accessLogEntry struct {
+	time      time.Time
+	method    string
+	path      string
+	route     string
+	protocol  string
+	status    int
+	latency   time.Duration
+	bytes     int
+	clientIP  string
+	user      string
+	requestID string
+	userAgent string
+	referer   string
+}
+
+func newAccessLogEntry(c *gin.Context, start time.Time) accessLogEntry {
+	bytes := c.Writer.Size()
+	if bytes < 0 {
+		bytes = 0
+	}
+
+	return accessLogEntry{
+		time:      start,
+		method:    c.Request.Method,
+		path:      c.Request.URL.RequestURI(),
+		route:     RoutePath(c),
+		protocol:  c.Request.Proto,
+		status:    c.Writer.Status(),
+		latency:   time.Since(start),
+		bytes:     bytes,
+		clientIP:  ClientIP(c),
+		user:      AuthenticatedUser(c),
+		requestID: RequestID(c),
+		userAgent: c.Request.UserAgent(),
+		referer:   c.Request.Referer(),
+	}
+}
+
+func (e accessLogEntry) value(field string) (string, interface{}, bool) {
+	switch field {
+	case "time":
+		return "time", e.time.Format(time.RFC3339Nano), true
+	case "method":
+		return "method", e.method, true
+	case "path":
+		return "path", e.path, true
+	case "route":
+		return "route", e.route, true
+	case "protocol":
+		return "protocol", e.protocol, true
+	case "status":
+		return "status", e.status, true
+	case "latency":
+		return "latency_ms", float64(e.latency) / float64(time.Millisecond), true
+	case "bytes":
+		return "bytes", e.bytes, true
+	case "client_ip":
+		return "client_ip", e.clientIP, true
+	case "user":
+		return "user", e.user, true
+	case "request_id":
+		return "request_id", e.requestID, true
+	case "user_agent":
+		return "user_agent", e.userAgent, true
+	case "referer":
+		return "referer", e.referer, true
+	}
+	return "", nil, false
+}
+
+func (e accessLogEntry) json(fields []string) []byte {
+	buffer := &bytes.Buffer{}
+	buffer.WriteByte('{')
+	for _, field := range fields {
+		key, value, ok := e.value(field)
+		if !ok {
+			continue
+		}
+
+		if buffer.Len() > 1 {
+			buffer.WriteByte(',')
+		}
+		keyJSON, _ := json.Marshal(key)
+		valueJSON, _ := json.Marshal(value)
+		buffer.Write(keyJSON)
+		buffer.WriteByte(':')
+		buffer.Write(valueJSON)
+	}
+	buffer.WriteString("}\n")
+	return buffer.Bytes()
+}
+
+func (e accessLogEntry) logfmt(fields []string) []byte {
+	pairs := []string{}
+	for _, field := range fields {
+		key, value, ok := e.value(field)
+		if !ok {
+			continue
+		}
+		pairs = append(pairs, key+"="+logfmtValue(value))
+	}
+	return []byte(strings.Join(pairs, " ") + "\n")
+}
+
+func logfmtValue(value interface{}) string {
+	var text string
+	switch value := value.(type) {
+	case float64:
+		text = strconv.FormatFloat(value, 'f', 3, 64)
+	default:
+		text = fmt.Sprint(value)
+	}
+
+	if text == "" || strings.ContainsAny(text, " =\"\t\n") {
+		return strconv.Quote(text)
+	}
+	return text
+}
+
+// combined formats the entry in the Apache combined log format.
+func (e accessLogEntry) combined() []byte {
+	return []byte(fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s %s %s\n",
+		dashIfEmpty(e.clientIP),
+		dashIfEmpty(e.user),
+		e.time.Format("02/Jan/2006:15:04:05 -0700"),
+		e.method, e.path, e.protocol,
+		e.status,
+		dashIfEmpty(bytesOrEmpty(e.bytes)),
+		strconv.Quote(dashIfEmpty(e.referer)),
+		strconv.Quote(dashIfEmpty(e.userAgent)),
+	))
+}
+
+func bytesOrEmpty(bytes int) string {
+	if bytes == 0 {
+		return ""
+	}
+	return strconv.Itoa(bytes)
+}
+
+func dashIfEmpty(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
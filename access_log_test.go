@@ -0,0 +1,250 @@
+package thruster_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tscolari/thruster"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Access log", func() {
+	var subject *thruster.Server
+	var config thruster.Config
+	var testServer *httptest.Server
+	var dir, logPath string
+
+	logLines := func() []string {
+		data, err := ioutil.ReadFile(logPath)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		Expect(err).ToNot(HaveOccurred())
+		return strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "thruster")
+		Expect(err).ToNot(HaveOccurred())
+		logPath = filepath.Join(dir, "access.log")
+
+		config = thruster.Config{
+			Hostname: "localhost",
+			Port:     8080,
+			AccessLog: thruster.AccessLog{
+				Enabled: true,
+				Output:  logPath,
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		engine := gin.New()
+		subject = thruster.NewServerWithEngine(config, engine)
+		subject.AddHandler(thruster.GET, "/users/:id", func(c *gin.Context) {
+			c.String(200, "OK")
+		})
+		subject.AddHandler(thruster.GET, "/health", func(c *gin.Context) {
+			c.String(200, "OK")
+		})
+		testServer = httptest.NewServer(engine)
+	})
+
+	AfterEach(func() {
+		testServer.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("logs the requests as JSON by default", func() {
+		makeSimpleRequest(thruster.GET, testServer.URL+"/users/1?full=true")
+
+		lines := logLines()
+		Expect(lines).To(HaveLen(1))
+
+		var entry map[string]interface{}
+		Expect(json.Unmarshal([]byte(lines[0]), &entry)).To(Succeed())
+		Expect(entry["method"]).To(Equal("GET"))
+		Expect(entry["path"]).To(Equal("/users/1?full=true"))
+		Expect(entry["route"]).To(Equal("/users/:id"))
+		Expect(entry["status"]).To(BeNumerically("==", 200))
+		Expect(entry["bytes"]).To(BeNumerically("==", 2))
+		Expect(entry).To(HaveKey("latency_ms"))
+		Expect(entry).To(HaveKey("time"))
+		Expect(entry["request_id"]).To(HaveLen(36))
+	})
+
+	Context("with selected fields", func() {
+		BeforeEach(func() {
+			config.AccessLog.Fields = []string{"method", "route", "user"}
+			config.HTTPAuth = []thruster.HTTPAuth{thruster.NewHTTPAuth("admin", "passwd")}
+		})
+
+		It("logs only them, including the authenticated user", func() {
+			request, err := http.NewRequest(thruster.GET, testServer.URL+"/users/1", nil)
+			Expect(err).ToNot(HaveOccurred())
+			request.SetBasicAuth("admin", "passwd")
+			_, err = http.DefaultClient.Do(request)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(logLines()).To(Equal([]string{`{"method":"GET","route":"/users/:id","user":"admin"}`}))
+		})
+
+		It("logs requests rejected by the HTTP auth", func() {
+			makeSimpleRequest(thruster.GET, testServer.URL+"/users/1")
+			Expect(logLines()).To(Equal([]string{`{"method":"GET","route":"/users/:id","user":""}`}))
+		})
+	})
+
+	Context("in logfmt format", func() {
+		BeforeEach(func() {
+			config.AccessLog.Format = thruster.LogFormatLogfmt
+			config.AccessLog.Fields = []string{"method", "route", "status", "user_agent"}
+		})
+
+		It("logs key=value pairs", func() {
+			request, err := http.NewRequest(thruster.GET, testServer.URL+"/users/1", nil)
+			Expect(err).ToNot(HaveOccurred())
+			request.Header.Set("User-Agent", "my agent")
+			_, err = http.DefaultClient.Do(request)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(logLines()).To(Equal([]string{`method=GET route=/users/:id status=200 user_agent="my agent"`}))
+		})
+	})
+
+	Context("in Apache combined format", func() {
+		BeforeEach(func() {
+			config.AccessLog.Format = thruster.LogFormatCombined
+		})
+
+		It("logs combined lines", func() {
+			makeSimpleRequest(thruster.GET, testServer.URL+"/users/1")
+
+			lines := logLines()
+			Expect(lines).To(HaveLen(1))
+			Expect(lines[0]).To(MatchRegexp(`^127\.0\.0\.1 - - \[.+\] "GET /users/1 HTTP/1\.1" 200 2 "-" "Go-http-client/1\.1"$`))
+		})
+	})
+
+	Context("with excluded paths", func() {
+		BeforeEach(func() {
+			config.AccessLog.ExcludePaths = []string{"/health"}
+		})
+
+		It("doesn't log them", func() {
+			makeSimpleRequest(thruster.GET, testServer.URL+"/health")
+			makeSimpleRequest(thruster.GET, testServer.URL+"/users/1")
+
+			lines := logLines()
+			Expect(lines).To(HaveLen(1))
+			Expect(lines[0]).To(ContainSubstring(`"/users/1"`))
+		})
+	})
+
+	Context("with size based rotation", func() {
+		BeforeEach(func() {
+			config.AccessLog.Format = thruster.LogFormatLogfmt
+			config.AccessLog.Fields = []string{"path"}
+			config.AccessLog.MaxSize = 1
+			config.AccessLog.MaxBackups = 1
+			Expect(ioutil.WriteFile(logPath, []byte(strings.Repeat("x", 1024*1024-5)+"\n"), 0644)).To(Succeed())
+		})
+
+		It("rotates the file once it's full", func() {
+			makeSimpleRequest(thruster.GET, testServer.URL+"/users/1")
+
+			Expect(logLines()).To(Equal([]string{"path=/users/1"}))
+			Expect(logPath + ".1").To(BeAnExistingFile())
+		})
+	})
+
+	It("logs the requests that match no route", func() {
+		resp := makeSimpleRequest(thruster.GET, testServer.URL+"/missing")
+		Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+
+		lines := logLines()
+		Expect(lines).To(HaveLen(1))
+		Expect(lines[0]).To(ContainSubstring(`"path":"/missing"`))
+		Expect(lines[0]).To(ContainSubstring(`"status":404`))
+	})
+
+	It("doesn't lose the lines written while a reload switches the file", func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				for j := 0; j < 20; j++ {
+					makeSimpleRequest(thruster.GET, testServer.URL+"/users/1").Body.Close()
+				}
+			}()
+		}
+
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+
+		otherPath := filepath.Join(dir, "other.log")
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+			default:
+				reloaded := config
+				if i%2 == 0 {
+					reloaded.AccessLog.Output = otherPath
+				}
+				Expect(subject.Reload(reloaded)).To(Succeed())
+				continue
+			}
+			break
+		}
+
+		other, _ := ioutil.ReadFile(otherPath)
+		Expect(len(logLines()) + strings.Count(string(other), "\n")).To(Equal(200))
+	})
+
+	It("reopens the file after Close", func() {
+		makeSimpleRequest(thruster.GET, testServer.URL+"/users/1")
+		Expect(subject.Close()).To(Succeed())
+		Expect(os.Rename(logPath, logPath+".old")).To(Succeed())
+
+		makeSimpleRequest(thruster.GET, testServer.URL+"/users/2")
+		Expect(logLines()).To(HaveLen(1))
+		Expect(logLines()[0]).To(ContainSubstring(`"path":"/users/2"`))
+	})
+
+	Context("when it is not enabled", func() {
+		BeforeEach(func() {
+			config.AccessLog.Enabled = false
+		})
+
+		It("doesn't log", func() {
+			makeSimpleRequest(thruster.GET, testServer.URL+"/users/1")
+			Expect(logLines()).To(BeEmpty())
+		})
+	})
+
+	It("validates the configuration", func() {
+		config.AccessLog.Format = "xml"
+		config.AccessLog.Fields = []string{"method", "cookies"}
+		config.AccessLog.SampleRate = 2
+
+		err := config.Validate()
+		Expect(err).To(MatchError(ContainSubstring(`access_log.format: must be one of json, logfmt or combined, got "xml"`)))
+		Expect(err).To(MatchError(ContainSubstring(`access_log.fields[1]: unknown field "cookies"`)))
+		Expect(err).To(MatchError(ContainSubstring(`access_log.sample_rate: must be between 0 and 1, got 2`)))
+	})
+})
@@ -0,0 +1,157 @@
+package thruster
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultAdminPrefix = "/debug"
+
+var startedAt = time.Now()
+
+type adminInfo struct {
+	GoVersion    string    `json:"go_version"`
+	Module       string    `json:"module,omitempty"`
+	Version      string    `json:"version,omitempty"`
+	Revision     string    `json:"vcs_revision,omitempty"`
+	RevisionTime string    `json:"vcs_time,omitempty"`
+	Modified     bool      `json:"vcs_modified,omitempty"`
+	Goroutines   int       `json:"goroutines"`
+	CPUs         int       `json:"cpus"`
+	GOMAXPROCS   int       `json:"gomaxprocs"`
+	HeapAlloc    uint64    `json:"heap_alloc_bytes"`
+	NumGC        uint32    `json:"num_gc"`
+	StartedAt    time.Time `json:"started_at"`
+	Uptime       string    `json:"uptime"`
+}
+
+func (a Admin) prefix() string {
+	if a.Prefix == "" {
+		return defaultAdminPrefix
+	}
+	return strings.TrimSuffix(a.Prefix, "/")
+}
+
+// AdminHandler returns the handler of the admin endpoints under the admin
+// prefix, for mounting them on a custom listener:
+//
+//	<prefix>/pprof/  net/http/pprof profiles
+//	<prefix>/vars    expvar variables
+//	<prefix>/info    build and runtime info
+//	<prefix>/config  effective config, with secrets redacted
+//
+// Requests are checked against the Admin.HTTPAuth credentials, and all
+// denied without any.
+func (s *Server) AdminHandler() http.Handler {
+	return s.adminHandler(s.currentConfig().Admin.prefix())
+}
+
+func (s *Server) adminHandler(prefix string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := authenticate(r, s.currentConfig().Admin.HTTPAuth); !ok {
+			s.serverMetrics.authFailures.Inc(prefix)
+			w.Header().Set("WWW-Authenticate", authRealm)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		path := strings.TrimPrefix(r.URL.Path, prefix)
+		switch {
+		case path == "/vars":
+			expvar.Handler().ServeHTTP(w, r)
+		case path == "/info":
+			s.serveAdminInfo(w)
+		case path == "/config":
+			s.serveAdminConfig(w)
+		case path == "/pprof/cmdline":
+			pprof.Cmdline(w, r)
+		case path == "/pprof/profile":
+			pprof.Profile(w, r)
+		case path == "/pprof/symbol":
+			pprof.Symbol(w, r)
+		case path == "/pprof/trace":
+			pprof.Trace(w, r)
+		case path == "/pprof/":
+			// pprof.Index only serves named profiles under /debug/pprof/.
+			pprof.Index(w, r)
+		case strings.HasPrefix(path, "/pprof/"):
+			pprof.Handler(strings.TrimPrefix(path, "/pprof/")).ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+func (s *Server) serveAdminInfo(w http.ResponseWriter) {
+	memStats := runtime.MemStats{}
+	runtime.ReadMemStats(&memStats)
+
+	info := adminInfo{
+		GoVersion:  runtime.Version(),
+		Goroutines: runtime.NumGoroutine(),
+		CPUs:       runtime.NumCPU(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		HeapAlloc:  memStats.HeapAlloc,
+		NumGC:      memStats.NumGC,
+		StartedAt:  startedAt,
+		Uptime:     time.Since(startedAt).Round(time.Second).String(),
+	}
+
+	if build, ok := debug.ReadBuildInfo(); ok {
+		info.Module = build.Main.Path
+		info.Version = build.Main.Version
+		for _, setting := range build.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				info.Revision = setting.Value
+			case "vcs.time":
+				info.RevisionTime = setting.Value
+			case "vcs.modified":
+				info.Modified = setting.Value == "true"
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(info)
+}
+
+func (s *Server) serveAdminConfig(w http.ResponseWriter) {
+	dump, err := s.currentConfig().Dump()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("dumping config: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-yaml; charset=utf-8")
+	w.Write(dump)
+}
+
+func (s *Server) mountAdmin() {
+	admin := s.currentConfig().Admin
+	if !admin.Enabled || admin.Address != "" {
+		return
+	}
+
+	// The admin endpoints have their own credentials instead of HTTPAuth,
+	// and CPU profiles and traces run for longer than handler timeouts.
+	handler := s.adminHandler(admin.prefix())
+	for _, method := range []string{GET, POST} {
+		s.handle(Route{Method: method, Path: admin.prefix() + "/*path", public: true, untimed: true, unlimited: true}, func(c *gin.Context) {
+			handler.ServeHTTP(c.Writer, c.Request)
+		})
+	}
+}
+
+func (s *Server) adminServer(admin Admin) *http.Server {
+	return s.newHTTPServer(admin.Address, s.adminHandler(admin.prefix()))
+}
@@ -0,0 +1,152 @@
+package thruster_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tscolari/thruster"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Admin", func() {
+	var config thruster.Config
+	var testServer *httptest.Server
+
+	get := func(path, username, password string) *http.Response {
+		request, err := http.NewRequest(thruster.GET, testServer.URL+path, nil)
+		Expect(err).ToNot(HaveOccurred())
+		if username != "" {
+			request.SetBasicAuth(username, password)
+		}
+		resp, err := http.DefaultClient.Do(request)
+		Expect(err).ToNot(HaveOccurred())
+		return resp
+	}
+
+	BeforeEach(func() {
+		config = thruster.Config{
+			HTTPAuth: []thruster.HTTPAuth{thruster.NewHTTPAuth("user", "secret")},
+			Admin: thruster.Admin{
+				Enabled:  true,
+				HTTPAuth: []thruster.HTTPAuth{thruster.NewHTTPAuth("admin", "admin-secret")},
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		engine := gin.New()
+		subject := thruster.NewServerWithEngine(config, engine)
+		subject.AddHandler(thruster.GET, "/test", func(c *gin.Context) {
+			c.String(200, "OK")
+		})
+		testServer = httptest.NewServer(engine)
+	})
+
+	AfterEach(func() {
+		testServer.Close()
+	})
+
+	It("requires the admin credentials", func() {
+		resp := get("/debug/vars", "", "")
+		Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+
+		resp = get("/debug/vars", "user", "secret")
+		Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+
+		resp = get("/debug/vars", "admin", "admin-secret")
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+	})
+
+	It("doesn't accept the admin credentials on other routes", func() {
+		resp := get("/test", "admin", "admin-secret")
+		Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("serves expvar", func() {
+		resp := get("/debug/vars", "admin", "admin-secret")
+
+		vars := map[string]interface{}{}
+		Expect(json.NewDecoder(resp.Body).Decode(&vars)).To(Succeed())
+		Expect(vars).To(HaveKey("memstats"))
+	})
+
+	It("serves pprof", func() {
+		resp := get("/debug/pprof/", "admin", "admin-secret")
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+		resp = get("/debug/pprof/goroutine?debug=1", "admin", "admin-secret")
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		body, _ := ioutil.ReadAll(resp.Body)
+		Expect(string(body)).To(ContainSubstring("goroutine profile"))
+	})
+
+	It("serves the build and runtime info", func() {
+		resp := get("/debug/info", "admin", "admin-secret")
+
+		info := map[string]interface{}{}
+		Expect(json.NewDecoder(resp.Body).Decode(&info)).To(Succeed())
+		Expect(info["go_version"]).ToNot(BeEmpty())
+		Expect(info["goroutines"]).To(BeNumerically(">", 0))
+	})
+
+	It("serves the redacted config", func() {
+		resp := get("/debug/config", "admin", "admin-secret")
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+		body, _ := ioutil.ReadAll(resp.Body)
+		Expect(string(body)).To(ContainSubstring("username: admin"))
+		Expect(string(body)).ToNot(ContainSubstring("admin-secret"))
+	})
+
+	Context("with a custom prefix", func() {
+		BeforeEach(func() {
+			config.Admin.Prefix = "/_admin"
+		})
+
+		It("serves the endpoints under it", func() {
+			resp := get("/_admin/info", "admin", "admin-secret")
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			resp = get("/debug/info", "admin", "admin-secret")
+			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Context("with an address", func() {
+		BeforeEach(func() {
+			config.Admin.Address = "localhost:9322"
+		})
+
+		It("doesn't serve the endpoints on the main listener", func() {
+			resp := get("/debug/info", "admin", "admin-secret")
+			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Describe("validation", func() {
+		It("requires credentials, on their own listener too", func() {
+			config := thruster.Config{Port: 8080, Admin: thruster.Admin{Enabled: true}}
+			Expect(config.Validate()).To(MatchError(ContainSubstring("admin.http_auth: can't be empty")))
+
+			config.Admin.Address = "localhost:9322"
+			Expect(config.Validate()).To(MatchError(ContainSubstring("admin.http_auth: can't be empty")))
+
+			config.Admin.HTTPAuth = []thruster.HTTPAuth{thruster.NewHTTPAuth("admin", "admin-secret")}
+			Expect(config.Validate()).To(Succeed())
+		})
+
+		It("rejects the root path as prefix", func() {
+			config := thruster.Config{Port: 8080, Admin: thruster.Admin{
+				Enabled:  true,
+				Prefix:   "/",
+				HTTPAuth: []thruster.HTTPAuth{thruster.NewHTTPAuth("admin", "admin-secret")},
+			}}
+			Expect(config.Validate()).To(MatchError(ContainSubstring("admin.prefix: can't be the root path")))
+		})
+	})
+})
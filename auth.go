@@ -0,0 +1,49 @@
+package thruster
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const authRealm = `Basic realm="Authorization Required"`
+
+// basicAuth checks the request credentials against the HTTPAuth list of the
+// current config, so reloaded credentials apply to the next request. The
+// authenticated username is stored under gin.AuthUserKey.
+func (s *Server) basicAuth(c *gin.Context) {
+	accounts := s.currentConfig().HTTPAuth
+	if len(accounts) == 0 {
+		return
+	}
+
+	if username, ok := authenticate(c.Request, accounts); ok {
+		c.Set(gin.AuthUserKey, username)
+		return
+	}
+
+	s.serverMetrics.authFailures.Inc(RoutePath(c))
+	c.Header("WWW-Authenticate", authRealm)
+	c.AbortWithStatus(http.StatusUnauthorized)
+}
+
+// authenticate returns the username of the account matching the request
+// basic auth credentials.
+func authenticate(request *http.Request, accounts []HTTPAuth) (string, bool) {
+	username, password, ok := request.BasicAuth()
+	if !ok {
+		return "", false
+	}
+
+	for _, account := range accounts {
+		if secureCompare(username, account.Username) && secureCompare(password, account.Password) {
+			return account.Username, true
+		}
+	}
+	return "", false
+}
+
+func secureCompare(given, actual string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(actual)) == 1
+}
@@ -0,0 +1,119 @@
+package thruster
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultMaxDecompressedBytes = 32 << 20
+
+// maxBodyBytes returns the body size limit of route, 0 meaning none.
+func (l Limits) maxBodyBytes(route Route) int64 {
+	if limit, found := l.Routes[route.Method+" "+route.Path]; found {
+		return limit
+	}
+	return l.MaxBodyBytes
+}
+
+func (l Limits) maxDecompressedBytes() int64 {
+	if l.MaxDecompressedBytes <= 0 {
+		return defaultMaxDecompressedBytes
+	}
+	return l.MaxDecompressedBytes
+}
+
+// limitBody enforces the body size limit of the route while the handler
+// reads the body, and decompresses gzip bodies when enabled. Reads past the
+// limit fail with ErrRequestTooLarge, and a 413 is sent unless the handler
+// already responded.
+func (s *Server) limitBody(c *gin.Context) {
+	limits := s.currentConfig().Limits
+	route, _ := CurrentRoute(c)
+	limit := limits.maxBodyBytes(route)
+	gzipped := limits.Gzip && strings.EqualFold(c.Request.Header.Get("Content-Encoding"), "gzip")
+	if limit <= 0 && !gzipped {
+		return
+	}
+
+	if limit > 0 && c.Request.ContentLength > limit {
+		abortWithError(c, http.StatusRequestEntityTooLarge, ErrRequestTooLarge)
+		return
+	}
+
+	body := &limitedBody{ReadCloser: c.Request.Body, remaining: limit}
+	if limit <= 0 {
+		body.remaining = -1
+	}
+	c.Request.Body = body
+
+	var decompressed *limitedBody
+	if gzipped {
+		reader, err := gzip.NewReader(body)
+		if err == ErrRequestTooLarge {
+			abortWithError(c, http.StatusRequestEntityTooLarge, err)
+			return
+		} else if err != nil {
+			abortWithError(c, http.StatusBadRequest, ErrBadRequest)
+			return
+		}
+
+		decompressed = &limitedBody{ReadCloser: gzipBody{Reader: reader, body: body}, remaining: limits.maxDecompressedBytes()}
+		c.Request.Body = decompressed
+		c.Request.ContentLength = -1
+		c.Request.Header.Del("Content-Encoding")
+		c.Request.Header.Del("Content-Length")
+	}
+
+	c.Next()
+
+	exceeded := body.exceeded || (decompressed != nil && decompressed.exceeded)
+	if exceeded && !c.Writer.Written() {
+		abortWithError(c, http.StatusRequestEntityTooLarge, ErrRequestTooLarge)
+	}
+}
+
+// limitedBody fails reads past its remaining bytes, -1 meaning unlimited.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	exceeded  bool
+}
+
+func (b *limitedBody) Read(data []byte) (int, error) {
+	if b.remaining < 0 {
+		return b.ReadCloser.Read(data)
+	}
+
+	if b.exceeded {
+		return 0, ErrRequestTooLarge
+	}
+
+	// Reads one byte past the limit to tell a body of exactly the limit
+	// from a larger one.
+	if int64(len(data)) > b.remaining+1 {
+		data = data[:b.remaining+1]
+	}
+
+	n, err := b.ReadCloser.Read(data)
+	if int64(n) > b.remaining {
+		b.exceeded = true
+		n = int(b.remaining)
+		err = ErrRequestTooLarge
+	}
+	b.remaining -= int64(n)
+	return n, err
+}
+
+type gzipBody struct {
+	*gzip.Reader
+	body io.Closer
+}
+
+func (b gzipBody) Close() error {
+	b.Reader.Close()
+	return b.body.Close()
+}
@@ -0,0 +1,162 @@
+package thruster_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tscolari/thruster"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Body limits", func() {
+	var config thruster.Config
+	var testServer *httptest.Server
+	var received string
+
+	post := func(path string, body []byte, headers map[string]string) *http.Response {
+		request, err := http.NewRequest(thruster.POST, testServer.URL+path, bytes.NewReader(body))
+		Expect(err).ToNot(HaveOccurred())
+		for key, value := range headers {
+			request.Header.Set(key, value)
+		}
+		resp, err := http.DefaultClient.Do(request)
+		Expect(err).ToNot(HaveOccurred())
+		return resp
+	}
+
+	// chunked hides the Content-Length, so the limit is enforced while the
+	// body is read.
+	chunked := func(path string, body []byte) *http.Response {
+		request, err := http.NewRequest(thruster.POST, testServer.URL+path, ioutil.NopCloser(bytes.NewReader(body)))
+		Expect(err).ToNot(HaveOccurred())
+		request.ContentLength = -1
+		resp, err := http.DefaultClient.Do(request)
+		Expect(err).ToNot(HaveOccurred())
+		return resp
+	}
+
+	gzipped := func(data []byte) []byte {
+		buffer := &bytes.Buffer{}
+		writer := gzip.NewWriter(buffer)
+		writer.Write(data)
+		writer.Close()
+		return buffer.Bytes()
+	}
+
+	BeforeEach(func() {
+		received = ""
+		config = thruster.Config{
+			Limits: thruster.Limits{
+				MaxBodyBytes: 10,
+				Routes:       map[string]int64{"POST /uploads": 100},
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		engine := gin.New()
+		subject := thruster.NewServerWithEngine(config, engine)
+		subject.AddJSONHandler(thruster.POST, "/json", func(c *gin.Context) (interface{}, error) {
+			data, err := ioutil.ReadAll(c.Request.Body)
+			if err != nil {
+				return nil, err
+			}
+			received = string(data)
+			return "OK", nil
+		})
+		subject.AddHandler(thruster.POST, "/uploads", func(c *gin.Context) {
+			data, _ := ioutil.ReadAll(c.Request.Body)
+			received = string(data)
+		})
+		testServer = httptest.NewServer(engine)
+	})
+
+	AfterEach(func() {
+		testServer.Close()
+	})
+
+	It("accepts bodies up to the limit", func() {
+		resp := post("/json", []byte("0123456789"), nil)
+		Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+		Expect(received).To(Equal("0123456789"))
+	})
+
+	It("rejects larger bodies by their Content-Length", func() {
+		resp := post("/json", []byte("0123456789a"), nil)
+		Expect(resp.StatusCode).To(Equal(http.StatusRequestEntityTooLarge))
+
+		body := map[string]string{}
+		Expect(json.NewDecoder(resp.Body).Decode(&body)).To(Succeed())
+		Expect(body["error"]).To(Equal(thruster.ErrRequestTooLarge.Error()))
+		Expect(received).To(BeEmpty())
+	})
+
+	It("rejects larger bodies while they are read", func() {
+		resp := chunked("/json", []byte(strings.Repeat("a", 50)))
+		Expect(resp.StatusCode).To(Equal(http.StatusRequestEntityTooLarge))
+	})
+
+	It("responds with 413 when the handler ignores the read error", func() {
+		resp := chunked("/uploads", []byte(strings.Repeat("a", 150)))
+		Expect(resp.StatusCode).To(Equal(http.StatusRequestEntityTooLarge))
+	})
+
+	It("applies the route limits", func() {
+		resp := post("/uploads", []byte(strings.Repeat("a", 100)), nil)
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(received).To(HaveLen(100))
+	})
+
+	Context("with gzip enabled", func() {
+		BeforeEach(func() {
+			config.Limits.Gzip = true
+			config.Limits.MaxDecompressedBytes = 1000
+		})
+
+		It("decompresses gzip bodies", func() {
+			resp := post("/uploads", gzipped([]byte("hello")), map[string]string{"Content-Encoding": "gzip"})
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(received).To(Equal("hello"))
+		})
+
+		It("caps the decompressed size", func() {
+			resp := post("/uploads", gzipped([]byte(strings.Repeat("a", 10000))), map[string]string{"Content-Encoding": "gzip"})
+			Expect(resp.StatusCode).To(Equal(http.StatusRequestEntityTooLarge))
+		})
+
+		It("rejects invalid gzip bodies", func() {
+			resp := post("/uploads", []byte("not gzip"), map[string]string{"Content-Encoding": "gzip"})
+			Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+		})
+	})
+
+	Context("with gzip disabled", func() {
+		It("passes gzip bodies through", func() {
+			data := gzipped([]byte("hello"))
+			resp := post("/uploads", data, map[string]string{"Content-Encoding": "gzip"})
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(received).To(Equal(string(data)))
+		})
+	})
+
+	Describe("validation", func() {
+		It("rejects negative limits", func() {
+			config := thruster.Config{Port: 8080, Limits: thruster.Limits{
+				MaxBodyBytes: -1,
+				Routes:       map[string]int64{"POST /uploads": -1},
+			}}
+
+			err := config.Validate()
+			Expect(err).To(MatchError(ContainSubstring("limits.max_body_bytes")))
+			Expect(err).To(MatchError(ContainSubstring("limits.routes[POST /uploads]")))
+		})
+	})
+})
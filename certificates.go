@@ -0,0 +1,78 @@
+package thruster
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+// certificateCache holds the key pair of the current config, loaded either
+// from files or from inlined PEM blocks. It's replaced on reload, so renewed
+// certificates are served to new connections without a restart.
+type certificateCache struct {
+	mutex       sync.Mutex
+	certificate string
+	publicKey   string
+	keyPair     *tls.Certificate
+}
+
+func (s *Server) certificateCache() *certificateCache {
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+
+	if s.certificates == nil {
+		s.certificates = &certificateCache{}
+	}
+	return s.certificates
+}
+
+func (s *Server) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return s.certificateCache().get(s.currentConfig())
+}
+
+func (c *certificateCache) get(config Config) (*tls.Certificate, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.keyPair != nil && c.certificate == config.Certificate && c.publicKey == config.PublicKey {
+		return c.keyPair, nil
+	}
+
+	keyPair, err := loadKeyPair(config.Certificate, config.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	c.certificate = config.Certificate
+	c.publicKey = config.PublicKey
+	c.keyPair = &keyPair
+	return c.keyPair, nil
+}
+
+func loadKeyPair(certificate, publicKey string) (tls.Certificate, error) {
+	if isPEM(certificate) || isPEM(publicKey) {
+		certPEM, err := readPEM(certificate)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		keyPEM, err := readPEM(publicKey)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		return tls.X509KeyPair(certPEM, keyPEM)
+	}
+
+	return tls.LoadX509KeyPair(certificate, publicKey)
+}
+
+func readPEM(value string) ([]byte, error) {
+	if isPEM(value) {
+		return []byte(value), nil
+	}
+	return ioutil.ReadFile(value)
+}
+
+func isPEM(value string) bool {
+	return strings.Contains(value, "-----BEGIN")
+}
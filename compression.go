@@ -0,0 +1,267 @@
+package thruster
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultCompressionMinSize = 1024
+
+// DefaultCompressionContentTypes are compressed when
+// Compression.ContentTypes is empty.
+var DefaultCompressionContentTypes = []string{
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+	"text/*",
+}
+
+var compressorPools = struct {
+	sync.Mutex
+	pools map[string]*sync.Pool
+}{pools: map[string]*sync.Pool{}}
+
+type compressor interface {
+	io.WriteCloser
+	Reset(io.Writer)
+}
+
+// getCompressor returns a pooled writer for the encoding and level, which
+// putCompressor returns to the pool once closed.
+func getCompressor(encoding string, level int, writer io.Writer) compressor {
+	key := encoding + ":" + strconv.Itoa(level)
+
+	compressorPools.Lock()
+	pool, found := compressorPools.pools[key]
+	if !found {
+		pool = &sync.Pool{New: func() interface{} {
+			if encoding == "gzip" {
+				compressor, _ := gzip.NewWriterLevel(nil, level)
+				return compressor
+			}
+			compressor, _ := flate.NewWriter(nil, level)
+			return compressor
+		}}
+		compressorPools.pools[key] = pool
+	}
+	compressorPools.Unlock()
+
+	compressor := pool.Get().(compressor)
+	compressor.Reset(writer)
+	return compressor
+}
+
+func putCompressor(encoding string, level int, compressor compressor) {
+	compressorPools.Lock()
+	pool := compressorPools.pools[encoding+":"+strconv.Itoa(level)]
+	compressorPools.Unlock()
+	pool.Put(compressor)
+}
+
+func (c Compression) level() int {
+	if c.Level == 0 {
+		return flate.DefaultCompression
+	}
+	return c.Level
+}
+
+func (c Compression) minSize() int {
+	if c.MinSize <= 0 {
+		return defaultCompressionMinSize
+	}
+	return c.MinSize
+}
+
+func (c Compression) compressible(contentType string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	contentTypes := c.ContentTypes
+	if len(contentTypes) == 0 {
+		contentTypes = DefaultCompressionContentTypes
+	}
+
+	for _, allowed := range contentTypes {
+		allowed = strings.ToLower(allowed)
+		if allowed == mediaType || (strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateEncoding picks gzip or deflate from the Accept-Encoding header,
+// by their quality, gzip winning ties. "*" stands for the encodings not
+// listed, so "gzip;q=0, *" picks deflate.
+func negotiateEncoding(acceptEncoding string) string {
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		encoding := strings.ToLower(strings.TrimSpace(fields[0]))
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if value, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+					quality = value
+				}
+			}
+		}
+		qualities[encoding] = quality
+	}
+
+	best, bestQuality := "", 0.0
+	for _, encoding := range []string{"gzip", "deflate"} {
+		quality, found := qualities[encoding]
+		if !found {
+			quality = qualities["*"]
+		}
+		if quality > bestQuality {
+			best, bestQuality = encoding, quality
+		}
+	}
+	return best
+}
+
+// compress compresses the responses of at least MinSize bytes, with an
+// allowed content type, in the encoding negotiated with the client.
+// Responses already encoded, or flushed before reaching MinSize, e.g.
+// streams, are sent as they are.
+func (s *Server) compress(c *gin.Context) {
+	config := s.currentConfig().Compression
+	if !config.Enabled {
+		return
+	}
+
+	addVary(c.Writer.Header(), "Accept-Encoding")
+	encoding := negotiateEncoding(c.Request.Header.Get("Accept-Encoding"))
+	if encoding == "" || c.Request.Method == "HEAD" {
+		return
+	}
+
+	writer := &compressWriter{
+		ResponseWriter: c.Writer,
+		config:         config,
+		encoding:       encoding,
+	}
+	c.Writer = writer
+	defer func() {
+		writer.finish()
+		c.Writer = writer.ResponseWriter
+	}()
+
+	c.Next()
+}
+
+func addVary(header http.Header, value string) {
+	for _, vary := range header["Vary"] {
+		for _, existing := range strings.Split(vary, ",") {
+			if strings.EqualFold(strings.TrimSpace(existing), value) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", value)
+}
+
+// compressWriter buffers the first MinSize bytes of the response to decide
+// if it's worth compressing.
+type compressWriter struct {
+	gin.ResponseWriter
+
+	config     Compression
+	encoding   string
+	buffer     bytes.Buffer
+	decided    bool
+	compressor compressor
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if w.decided {
+		return w.write(data)
+	}
+
+	w.buffer.Write(data)
+	if w.buffer.Len() >= w.config.minSize() {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *compressWriter) WriteString(data string) (int, error) {
+	return w.Write([]byte(data))
+}
+
+func (w *compressWriter) write(data []byte) (int, error) {
+	if w.compressor != nil {
+		return w.compressor.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// decide starts the response, compressed when allowed to and the response
+// qualifies, and writes the buffered bytes.
+func (w *compressWriter) decide(allowCompression bool) error {
+	w.decided = true
+	header := w.Header()
+	if header.Get("Content-Type") == "" && w.buffer.Len() > 0 {
+		header.Set("Content-Type", http.DetectContentType(w.buffer.Bytes()))
+	}
+
+	status := w.Status()
+	if allowCompression && header.Get("Content-Encoding") == "" && status != http.StatusNoContent &&
+		status != http.StatusNotModified && w.config.compressible(header.Get("Content-Type")) {
+		header.Set("Content-Encoding", w.encoding)
+		header.Del("Content-Length")
+		if etag := header.Get("ETag"); etag != "" {
+			header.Set("ETag", encodedETag(etag, w.encoding))
+		}
+		w.compressor = getCompressor(w.encoding, w.config.level(), w.ResponseWriter)
+	}
+
+	if w.buffer.Len() == 0 {
+		return nil
+	}
+	_, err := w.write(w.buffer.Bytes())
+	w.buffer.Reset()
+	return err
+}
+
+func (w *compressWriter) Written() bool {
+	return w.decided || w.buffer.Len() > 0 || w.ResponseWriter.Written()
+}
+
+// Flush sends the buffered bytes as they are, so streams aren't held back
+// waiting for MinSize.
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(false)
+	}
+	if w.compressor != nil {
+		if flusher, ok := w.compressor.(interface{ Flush() error }); ok {
+			flusher.Flush()
+		}
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *compressWriter) finish() {
+	if !w.decided {
+		w.decide(w.buffer.Len() >= w.config.minSize())
+	}
+
+	if w.compressor != nil {
+		w.compressor.Close()
+		putCompressor(w.encoding, w.config.level(), w.compressor)
+		w.compressor = nil
+	}
+}
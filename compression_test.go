@@ -0,0 +1,186 @@
+package thruster_test
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tscolari/thruster"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Compression", func() {
+	var config thruster.Config
+	var testServer *httptest.Server
+	large := strings.Repeat("thruster ", 500)
+
+	get := func(path, acceptEncoding string) *http.Response {
+		request, err := http.NewRequest(thruster.GET, testServer.URL+path, nil)
+		Expect(err).ToNot(HaveOccurred())
+		request.Header.Set("Accept-Encoding", acceptEncoding)
+		// A custom transport doesn't decompress the responses itself.
+		resp, err := (&http.Transport{DisableCompression: true}).RoundTrip(request)
+		Expect(err).ToNot(HaveOccurred())
+		return resp
+	}
+
+	BeforeEach(func() {
+		config = thruster.Config{Compression: thruster.Compression{Enabled: true}}
+	})
+
+	JustBeforeEach(func() {
+		engine := gin.New()
+		subject := thruster.NewServerWithEngine(config, engine)
+		subject.AddJSONHandler(thruster.GET, "/large", func(c *gin.Context) (interface{}, error) {
+			return large, nil
+		})
+		subject.AddJSONHandler(thruster.GET, "/small", func(c *gin.Context) (interface{}, error) {
+			return "small", nil
+		})
+		subject.AddHandler(thruster.GET, "/image", func(c *gin.Context) {
+			c.Data(200, "image/png", []byte(large))
+		})
+		subject.AddHandler(thruster.GET, "/encoded", func(c *gin.Context) {
+			c.Header("Content-Encoding", "br")
+			c.Data(200, "application/json", []byte(large))
+		})
+		subject.AddHandler(thruster.GET, "/stream", func(c *gin.Context) {
+			c.Header("Content-Type", "text/plain")
+			c.Writer.Write([]byte("first"))
+			c.Writer.Flush()
+			c.Writer.Write([]byte(large))
+		})
+		testServer = httptest.NewServer(engine)
+	})
+
+	AfterEach(func() {
+		testServer.Close()
+	})
+
+	It("compresses large responses with gzip", func() {
+		resp := get("/large", "gzip, deflate")
+		Expect(resp.Header.Get("Content-Encoding")).To(Equal("gzip"))
+		Expect(resp.Header.Get("Vary")).To(Equal("Accept-Encoding"))
+
+		reader, err := gzip.NewReader(resp.Body)
+		Expect(err).ToNot(HaveOccurred())
+		body, _ := ioutil.ReadAll(reader)
+		Expect(string(body)).To(ContainSubstring(large))
+	})
+
+	It("compresses with deflate when preferred", func() {
+		resp := get("/large", "gzip;q=0.5, deflate")
+		Expect(resp.Header.Get("Content-Encoding")).To(Equal("deflate"))
+
+		body, _ := ioutil.ReadAll(flate.NewReader(resp.Body))
+		Expect(string(body)).To(ContainSubstring(large))
+	})
+
+	It("doesn't compress without an accepted encoding", func() {
+		resp := get("/large", "br, gzip;q=0")
+		Expect(resp.Header.Get("Content-Encoding")).To(BeEmpty())
+		Expect(resp.Header.Get("Vary")).To(Equal("Accept-Encoding"))
+	})
+
+	It("keeps the explicitly refused encodings out of *", func() {
+		resp := get("/large", "gzip;q=0, *")
+		Expect(resp.Header.Get("Content-Encoding")).To(Equal("deflate"))
+
+		resp = get("/large", "gzip;q=0, deflate;q=0, *")
+		Expect(resp.Header.Get("Content-Encoding")).To(BeEmpty())
+	})
+
+	It("doesn't compress small responses", func() {
+		resp := get("/small", "gzip")
+		Expect(resp.Header.Get("Content-Encoding")).To(BeEmpty())
+		body, _ := ioutil.ReadAll(resp.Body)
+		Expect(strings.TrimSpace(string(body))).To(Equal(`"small"`))
+	})
+
+	It("doesn't compress other content types", func() {
+		resp := get("/image", "gzip")
+		Expect(resp.Header.Get("Content-Encoding")).To(BeEmpty())
+	})
+
+	It("doesn't compress encoded responses", func() {
+		resp := get("/encoded", "gzip")
+		Expect(resp.Header.Get("Content-Encoding")).To(Equal("br"))
+		body, _ := ioutil.ReadAll(resp.Body)
+		Expect(string(body)).To(Equal(large))
+	})
+
+	It("doesn't compress streams", func() {
+		resp := get("/stream", "gzip")
+		Expect(resp.Header.Get("Content-Encoding")).To(BeEmpty())
+		body, _ := ioutil.ReadAll(resp.Body)
+		Expect(string(body)).To(Equal("first" + large))
+	})
+
+	Context("with a lower threshold", func() {
+		BeforeEach(func() {
+			config.Compression.MinSize = 5
+		})
+
+		It("compresses smaller responses", func() {
+			resp := get("/small", "gzip")
+			Expect(resp.Header.Get("Content-Encoding")).To(Equal("gzip"))
+		})
+	})
+
+	Context("with ETags", func() {
+		BeforeEach(func() {
+			config.ETags.Enabled = true
+		})
+
+		It("suffixes the strong tags of compressed responses with the encoding", func() {
+			identity := get("/large", "identity").Header.Get("ETag")
+			Expect(identity).ToNot(BeEmpty())
+
+			resp := get("/large", "gzip")
+			Expect(resp.Header.Get("Content-Encoding")).To(Equal("gzip"))
+			Expect(resp.Header.Get("ETag")).To(Equal(strings.TrimSuffix(identity, `"`) + `-gzip"`))
+		})
+
+		It("matches the suffixed tags in If-None-Match", func() {
+			etag := get("/large", "gzip").Header.Get("ETag")
+
+			request, err := http.NewRequest(thruster.GET, testServer.URL+"/large", nil)
+			Expect(err).ToNot(HaveOccurred())
+			request.Header.Set("Accept-Encoding", "gzip")
+			request.Header.Set("If-None-Match", etag)
+			resp, err := (&http.Transport{DisableCompression: true}).RoundTrip(request)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusNotModified))
+		})
+
+		Context("when they're weak", func() {
+			BeforeEach(func() {
+				config.ETags.Weak = true
+			})
+
+			It("keeps them", func() {
+				identity := get("/large", "identity").Header.Get("ETag")
+				Expect(identity).To(HavePrefix("W/"))
+				Expect(get("/large", "gzip").Header.Get("ETag")).To(Equal(identity))
+			})
+		})
+	})
+
+	Describe("validation", func() {
+		It("rejects invalid levels", func() {
+			config := thruster.Config{Port: 8080, Compression: thruster.Compression{Level: 10}}
+			Expect(config.Validate()).To(MatchError(ContainSubstring("compression.level")))
+		})
+
+		It("rejects negative minimum sizes", func() {
+			config := thruster.Config{Port: 8080, Compression: thruster.Compression{MinSize: -1}}
+			Expect(config.Validate()).To(MatchError(ContainSubstring("compression.min_size")))
+		})
+	})
+})
@@ -0,0 +1,228 @@
+package thruster
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultQueueTimeout      = time.Second
+	defaultShedRetryAfter    = time.Second
+	defaultAdaptiveMaxFlight = 1000
+
+	shedQueueFull    = "queue_full"
+	shedQueueTimeout = "queue_timeout"
+)
+
+// concurrencyLimiter admits up to limit requests at once, queueing the
+// next ones in arrival order. With adaptive, limit follows the latency
+// instead: it grows by one per request under the target latency, and is cut
+// by a tenth, at most once per target latency, when over.
+type concurrencyLimiter struct {
+	mutex     sync.Mutex
+	limit     int
+	inFlight  int
+	waiters   []chan struct{}
+	adaptive  bool
+	decreased time.Time
+}
+
+func (s *Server) concurrencyLimiter(key string) *concurrencyLimiter {
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+
+	if s.concurrencyLimiters == nil {
+		s.concurrencyLimiters = map[string]*concurrencyLimiter{}
+	}
+	limiter, found := s.concurrencyLimiters[key]
+	if !found {
+		limiter = &concurrencyLimiter{}
+		s.concurrencyLimiters[key] = limiter
+	}
+	return limiter
+}
+
+// configure applies a reloaded limit. The adaptive limit is only bounded by
+// it, starting from it.
+func (l *concurrencyLimiter) configure(limit int, adaptive bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if !adaptive || !l.adaptive || l.limit > limit {
+		l.limit = limit
+	}
+	l.adaptive = adaptive
+	l.handOver()
+}
+
+// acquire waits for a slot, for up to timeout with at most maxQueue
+// requests waiting, and returns why it gave up otherwise.
+func (l *concurrencyLimiter) acquire(maxQueue int, timeout time.Duration) (bool, string) {
+	l.mutex.Lock()
+	// Requests only skip the queue when it's empty, so they can't take the
+	// slots of the waiters.
+	if len(l.waiters) == 0 && l.inFlight < l.limit {
+		l.inFlight++
+		l.mutex.Unlock()
+		return true, ""
+	}
+
+	if len(l.waiters) >= maxQueue {
+		l.mutex.Unlock()
+		return false, shedQueueFull
+	}
+
+	waiter := make(chan struct{})
+	l.waiters = append(l.waiters, waiter)
+	l.mutex.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-waiter:
+		return true, ""
+	case <-timer.C:
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for i, queued := range l.waiters {
+		if queued == waiter {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			return false, shedQueueTimeout
+		}
+	}
+	// The slot was handed over while timing out.
+	return true, ""
+}
+
+// release frees the slot, handing it over to the first waiter unless the
+// limit was lowered meanwhile.
+func (l *concurrencyLimiter) release() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.inFlight--
+	l.handOver()
+}
+
+// handOver gives the free slots to the waiters, in arrival order.
+func (l *concurrencyLimiter) handOver() {
+	for len(l.waiters) > 0 && l.inFlight < l.limit {
+		l.inFlight++
+		close(l.waiters[0])
+		l.waiters = l.waiters[1:]
+	}
+}
+
+// observe adapts the limit to the latency of a request, up to max.
+func (l *concurrencyLimiter) observe(latency, target time.Duration, max int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.adaptive {
+		l.adapt(latency, target, max)
+		l.handOver()
+	}
+}
+
+func (l *concurrencyLimiter) adapt(latency, target time.Duration, max int) {
+	if latency <= target {
+		if l.limit < max {
+			l.limit++
+		}
+		return
+	}
+
+	if time.Since(l.decreased) < target {
+		return
+	}
+	l.decreased = time.Now()
+	l.limit -= l.limit / 10
+	if l.limit < 1 {
+		l.limit = 1
+	}
+}
+
+func (c Concurrency) maxInFlight() int {
+	if c.MaxInFlight <= 0 && c.Adaptive.Enabled {
+		return defaultAdaptiveMaxFlight
+	}
+	return c.MaxInFlight
+}
+
+func (c Concurrency) queueTimeout() time.Duration {
+	if c.QueueTimeout <= 0 {
+		return defaultQueueTimeout
+	}
+	return c.QueueTimeout
+}
+
+func (c Concurrency) retryAfter() time.Duration {
+	if c.RetryAfter <= 0 {
+		return defaultShedRetryAfter
+	}
+	return c.RetryAfter
+}
+
+type limitedSlot struct {
+	limiter *concurrencyLimiter
+	max     int
+}
+
+// limitConcurrency sheds the requests over the global or route in-flight
+// limits with a 503, once the wait queue is full or they waited for too
+// long. The built-in health, metrics and admin endpoints are exempt.
+func (s *Server) limitConcurrency(c *gin.Context) {
+	config := s.currentConfig().Concurrency
+	route, _ := CurrentRoute(c)
+	if route.unlimited {
+		return
+	}
+
+	// The global limiter is always acquired first, so requests don't hold
+	// a slot each waiting for the other.
+	routeKey := route.Method + " " + route.Path
+	slots := []limitedSlot{}
+	for _, key := range []string{"", routeKey} {
+		max := config.Routes[key]
+		if key == "" {
+			max = config.maxInFlight()
+		}
+
+		if max > 0 {
+			limiter := s.concurrencyLimiter(key)
+			limiter.configure(max, config.Adaptive.Enabled)
+			slots = append(slots, limitedSlot{limiter: limiter, max: max})
+		}
+	}
+
+	for i, slot := range slots {
+		if ok, reason := slot.limiter.acquire(config.MaxQueue, config.queueTimeout()); !ok {
+			for _, acquired := range slots[:i] {
+				acquired.limiter.release()
+			}
+
+			s.serverMetrics.shed.Inc(route.Path, reason)
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(config.retryAfter())))
+			abortWithError(c, http.StatusServiceUnavailable, ErrServiceUnavailable)
+			return
+		}
+	}
+
+	start := time.Now()
+	defer func() {
+		latency := time.Since(start)
+		for _, slot := range slots {
+			slot.limiter.observe(latency, config.Adaptive.TargetLatency, slot.max)
+			slot.limiter.release()
+		}
+	}()
+
+	c.Next()
+}
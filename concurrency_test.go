@@ -0,0 +1,161 @@
+package thruster_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tscolari/thruster"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Concurrency limits", func() {
+	var subject *thruster.Server
+	var config thruster.Config
+	var testServer *httptest.Server
+	var release chan struct{}
+	var started chan struct{}
+
+	getAsync := func(path string) chan *http.Response {
+		responses := make(chan *http.Response, 1)
+		go func() {
+			defer GinkgoRecover()
+			responses <- makeSimpleRequest(thruster.GET, testServer.URL+path)
+		}()
+		return responses
+	}
+
+	BeforeEach(func() {
+		config = thruster.Config{
+			Metrics: thruster.Metrics{Enabled: true},
+			Health:  thruster.Health{Enabled: true},
+			Concurrency: thruster.Concurrency{
+				MaxInFlight:  1,
+				QueueTimeout: 50 * time.Millisecond,
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		release = make(chan struct{})
+		started = make(chan struct{}, 10)
+
+		engine := gin.New()
+		subject = thruster.NewServerWithEngine(config, engine)
+
+		blocked, running := release, started
+		subject.AddHandler(thruster.GET, "/slow", func(c *gin.Context) {
+			running <- struct{}{}
+			<-blocked
+			c.String(200, "OK")
+		})
+		subject.AddJSONHandler(thruster.GET, "/fast", func(c *gin.Context) (interface{}, error) {
+			return "OK", nil
+		})
+		testServer = httptest.NewServer(engine)
+	})
+
+	AfterEach(func() {
+		testServer.Close()
+	})
+
+	It("sheds the requests over the limit", func() {
+		slow := getAsync("/slow")
+		Eventually(started).Should(Receive())
+
+		resp := makeSimpleRequest(thruster.GET, testServer.URL+"/fast")
+		Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+		Expect(resp.Header.Get("Retry-After")).To(Equal("1"))
+
+		body := map[string]string{}
+		Expect(json.NewDecoder(resp.Body).Decode(&body)).To(Succeed())
+		Expect(body["error"]).To(Equal(thruster.ErrServiceUnavailable.Error()))
+
+		close(release)
+		Expect((<-slow).StatusCode).To(Equal(http.StatusOK))
+		Expect(makeSimpleRequest(thruster.GET, testServer.URL+"/fast").StatusCode).To(Equal(http.StatusOK))
+	})
+
+	It("exempts the health and metrics endpoints", func() {
+		getAsync("/slow")
+		Eventually(started).Should(Receive())
+
+		Expect(makeSimpleRequest(thruster.GET, testServer.URL+"/fast").StatusCode).To(Equal(http.StatusServiceUnavailable))
+		Expect(makeSimpleRequest(thruster.GET, testServer.URL+"/readyz").StatusCode).To(Equal(http.StatusOK))
+
+		resp := makeSimpleRequest(thruster.GET, testServer.URL+"/metrics")
+		data, _ := ioutil.ReadAll(resp.Body)
+		close(release)
+
+		Expect(string(data)).To(ContainSubstring(`thruster_http_shed_total{route="/fast",reason="queue_full"}`))
+	})
+
+	Context("with a wait queue", func() {
+		BeforeEach(func() {
+			config.Concurrency.MaxQueue = 1
+			config.Concurrency.QueueTimeout = time.Second
+		})
+
+		It("queues the requests until a slot is free", func() {
+			slow := getAsync("/slow")
+			Eventually(started).Should(Receive())
+
+			queued := getAsync("/fast")
+			Consistently(queued, 50*time.Millisecond).ShouldNot(Receive())
+
+			resp := makeSimpleRequest(thruster.GET, testServer.URL+"/fast")
+			Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+
+			close(release)
+			Expect((<-slow).StatusCode).To(Equal(http.StatusOK))
+			Expect((<-queued).StatusCode).To(Equal(http.StatusOK))
+		})
+
+		It("hands the slots of a raised limit to the queue first", func() {
+			defer close(release)
+			getAsync("/slow")
+			Eventually(started).Should(Receive())
+
+			queued := getAsync("/fast")
+			Consistently(queued, 50*time.Millisecond).ShouldNot(Receive())
+
+			config.Concurrency.MaxInFlight = 2
+			Expect(subject.Reload(config)).To(Succeed())
+			getAsync("/slow")
+
+			var resp *http.Response
+			Eventually(queued, 500*time.Millisecond).Should(Receive(&resp))
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		})
+	})
+
+	Context("with a route limit", func() {
+		BeforeEach(func() {
+			config.Concurrency.MaxInFlight = 0
+			config.Concurrency.Routes = map[string]int{"GET /slow": 1}
+		})
+
+		It("applies it to that route only", func() {
+			getAsync("/slow")
+			Eventually(started).Should(Receive())
+
+			Expect(makeSimpleRequest(thruster.GET, testServer.URL+"/fast").StatusCode).To(Equal(http.StatusOK))
+			Expect(makeSimpleRequest(thruster.GET, testServer.URL+"/slow").StatusCode).To(Equal(http.StatusServiceUnavailable))
+			close(release)
+		})
+	})
+
+	Describe("validation", func() {
+		It("requires a target latency for adaptive shedding", func() {
+			config := thruster.Config{Port: 8080, Concurrency: thruster.Concurrency{
+				Adaptive: thruster.AdaptiveShedding{Enabled: true},
+			}}
+			Expect(config.Validate()).To(MatchError(ContainSubstring("concurrency.adaptive.target_latency")))
+		})
+	})
+})
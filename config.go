@@ -1,9 +1,9 @@
 package thruster
 
 import (
+	"io"
 	"io/ioutil"
-
-	"gopkg.in/yaml.v2"
+	"time"
 )
 
 type Config struct {
@@ -13,12 +13,305 @@ type Config struct {
 	TLS      bool       `yaml:"tls"`
 
 	Certificate string `yaml:"certificate"`
-	PublicKey   string `yaml:"public_key"`
+	PublicKey   string `yaml:"public_key" secret:"pem"`
+	// CertificateFile and PublicKeyFile are read into Certificate and
+	// PublicKey, as mounted secrets.
+	CertificateFile string `yaml:"certificate_file"`
+	PublicKeyFile   string `yaml:"public_key_file"`
+
+	Explorer  Explorer  `yaml:"explorer"`
+	AccessLog AccessLog `yaml:"access_log"`
+	Metrics   Metrics   `yaml:"metrics"`
+	Tracing   Tracing   `yaml:"tracing"`
+	Health    Health    `yaml:"health"`
+	Admin     Admin     `yaml:"admin"`
+	Timeouts  Timeouts  `yaml:"timeouts"`
+	Limits    Limits    `yaml:"limits"`
+	RateLimit RateLimit `yaml:"rate_limit"`
+
+	Concurrency Concurrency `yaml:"concurrency"`
+	CORS        CORS        `yaml:"cors"`
+
+	SecurityHeaders SecurityHeaders `yaml:"security_headers"`
+	// TrustedProxies are the CIDRs or IPs of the proxies whose forwarding
+	// headers are trusted, to resolve the client IP and scheme.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	IPFilter       IPFilter `yaml:"ip_filter"`
+
+	Compression Compression `yaml:"compression"`
+	ETags       ETags       `yaml:"etags"`
+
+	ResponseCache ResponseCache `yaml:"response_cache"`
+	Idempotency   Idempotency   `yaml:"idempotency"`
+
+	RequestID RequestIDConfig `yaml:"request_id"`
+	// LogLevel is the lowest level of the messages thruster writes to
+	// stderr: "info" (default) for reloads, "warn" for failures of the
+	// stores and exporters, and "error" for panics only.
+	LogLevel string `yaml:"log_level"`
 }
 
 type HTTPAuth struct {
-	Username string `yaml:"username"`
-	Password string `yaml:"password"`
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password" secret:"true"`
+	PasswordFile string `yaml:"password_file"`
+}
+
+type Explorer struct {
+	Enabled        bool   `yaml:"enabled"`
+	Path           string `yaml:"path"`
+	AllowInRelease bool   `yaml:"allow_in_release"`
+}
+
+type AccessLog struct {
+	Enabled bool `yaml:"enabled"`
+	// Format is one of "json" (default), "logfmt" or "combined".
+	Format string   `yaml:"format"`
+	Fields []string `yaml:"fields"`
+
+	// Output is "stdout" (default), "stderr" or a file path. Files are
+	// rotated after MaxSize megabytes, keeping MaxBackups old files.
+	Output     string `yaml:"output"`
+	MaxSize    int    `yaml:"max_size"`
+	MaxBackups int    `yaml:"max_backups"`
+
+	// SampleRate logs only that fraction of the requests, server errors
+	// excluded. ExcludePaths are never logged; a trailing `*` matches a prefix.
+	SampleRate   float64  `yaml:"sample_rate"`
+	ExcludePaths []string `yaml:"exclude_paths"`
+}
+
+type Metrics struct {
+	Enabled bool   `yaml:"enabled"`
+	Path    string `yaml:"path"`
+	// Address, e.g. "127.0.0.1:9100", serves the metrics on a separate
+	// listener instead of the main one.
+	Address string `yaml:"address"`
+}
+
+type Tracing struct {
+	Enabled     bool   `yaml:"enabled"`
+	ServiceName string `yaml:"service_name"`
+	// Exporter is one of "stdout" (default), "file" or "otlp".
+	Exporter string `yaml:"exporter"`
+	File     string `yaml:"file"`
+	// Endpoint is the OTLP/HTTP traces endpoint, by default
+	// http://localhost:4318/v1/traces.
+	Endpoint string `yaml:"endpoint"`
+	// SampleRate is the fraction of new traces that are exported. Traces
+	// started upstream follow their traceparent sampled flag.
+	SampleRate float64 `yaml:"sample_rate"`
+}
+
+type RequestIDConfig struct {
+	// Header carries the request ID in both directions, by default
+	// X-Request-ID. Incoming IDs longer than MaxLength (128 by default) or
+	// with unexpected characters are replaced by a generated one.
+	Header    string `yaml:"header"`
+	MaxLength int    `yaml:"max_length"`
+}
+
+type Health struct {
+	Enabled       bool   `yaml:"enabled"`
+	LivenessPath  string `yaml:"liveness_path"`
+	ReadinessPath string `yaml:"readiness_path"`
+	// RequireAuth puts the endpoints behind the HTTP auth, which they
+	// bypass by default.
+	RequireAuth  bool          `yaml:"require_auth"`
+	CheckTimeout time.Duration `yaml:"check_timeout"`
+	// ShutdownDelay is how long Shutdown keeps serving, with readiness
+	// failing, before closing the listeners.
+	ShutdownDelay time.Duration `yaml:"shutdown_delay"`
+}
+
+// Admin serves the pprof, expvar, build info and config endpoints, under
+// Prefix on the main listener, or on their own listener when Address is set.
+type Admin struct {
+	Enabled bool   `yaml:"enabled"`
+	Prefix  string `yaml:"prefix"`
+	Address string `yaml:"address"`
+	// HTTPAuth is independent of the main HTTPAuth list, and required on
+	// every listener.
+	HTTPAuth []HTTPAuth `yaml:"http_auth"`
+}
+
+// Timeouts are unset (no timeout) by default. Read, ReadHeader, Write and
+// Idle apply to the main listener connections. Handler is the deadline of
+// each request, overridden per route in Routes, keyed by "METHOD /path"
+// as registered, e.g. "GET /users/:id".
+type Timeouts struct {
+	Read       time.Duration            `yaml:"read"`
+	ReadHeader time.Duration            `yaml:"read_header"`
+	Write      time.Duration            `yaml:"write"`
+	Idle       time.Duration            `yaml:"idle"`
+	Handler    time.Duration            `yaml:"handler"`
+	Routes     map[string]time.Duration `yaml:"routes"`
+}
+
+// Limits are in bytes, unset (no limit) by default. MaxBodyBytes is
+// overridden per route in Routes, keyed like Timeouts.Routes. With Gzip,
+// gzip encoded request bodies are decompressed for the handlers, up to
+// MaxDecompressedBytes (32MB by default). MaxHeaderBytes defaults to the
+// net/http one, 1MB.
+type Limits struct {
+	MaxBodyBytes         int64            `yaml:"max_body_bytes"`
+	Routes               map[string]int64 `yaml:"routes"`
+	Gzip                 bool             `yaml:"gzip"`
+	MaxDecompressedBytes int64            `yaml:"max_decompressed_bytes"`
+	MaxHeaderBytes       int              `yaml:"max_header_bytes"`
+}
+
+// RateLimit allows Requests per Period (1s by default) to each client, with
+// bursts of up to Burst (Requests by default). Key is "ip" (default),
+// "user", "route" or "header:<name>", e.g. "header:X-API-Key". Routes
+// override it per route, keyed like Timeouts.Routes; a route with 0
+// requests is not limited.
+type RateLimit struct {
+	Enabled  bool                     `yaml:"enabled"`
+	Requests int                      `yaml:"requests"`
+	Period   time.Duration            `yaml:"period"`
+	Burst    int                      `yaml:"burst"`
+	Key      string                   `yaml:"key"`
+	Routes   map[string]RateLimitRule `yaml:"routes"`
+}
+
+type RateLimitRule struct {
+	Requests int           `yaml:"requests"`
+	Period   time.Duration `yaml:"period"`
+	Burst    int           `yaml:"burst"`
+	Key      string        `yaml:"key"`
+}
+
+// Concurrency limits the requests handled at once, to MaxInFlight overall
+// and per route in Routes, keyed like Timeouts.Routes; 0 means no limit.
+// Requests over it wait in a queue of up to MaxQueue requests, for up to
+// QueueTimeout (1s by default), and are shed with a 503 otherwise.
+type Concurrency struct {
+	MaxInFlight  int              `yaml:"max_in_flight"`
+	MaxQueue     int              `yaml:"max_queue"`
+	QueueTimeout time.Duration    `yaml:"queue_timeout"`
+	RetryAfter   time.Duration    `yaml:"retry_after"`
+	Routes       map[string]int   `yaml:"routes"`
+	Adaptive     AdaptiveShedding `yaml:"adaptive"`
+}
+
+// AdaptiveShedding lowers the in-flight limits while the handlers take
+// longer than TargetLatency, and raises them back, up to the configured
+// ones (1000 overall by default), once they are faster again.
+type AdaptiveShedding struct {
+	Enabled       bool          `yaml:"enabled"`
+	TargetLatency time.Duration `yaml:"target_latency"`
+}
+
+// CORS answers the preflight requests of every route and sets the CORS
+// headers of the responses to the AllowedOrigins, which can be "*" or have
+// a wildcard subdomain, e.g. "https://*.example.com". AllowedMethods
+// defaults to the methods of the route, AllowedHeaders to Accept,
+// Authorization, Content-Type, the conditional and Idempotency-Key headers
+// and the request ID one, and ExposedHeaders to ETag, the rate limit ones
+// and the request ID one. Routes replace the policy per path, keyed by the
+// path as registered, e.g. "/users/:id".
+type CORS struct {
+	Enabled          bool                  `yaml:"enabled"`
+	AllowedOrigins   []string              `yaml:"allowed_origins"`
+	AllowedMethods   []string              `yaml:"allowed_methods"`
+	AllowedHeaders   []string              `yaml:"allowed_headers"`
+	ExposedHeaders   []string              `yaml:"exposed_headers"`
+	AllowCredentials bool                  `yaml:"allow_credentials"`
+	MaxAge           time.Duration         `yaml:"max_age"`
+	Routes           map[string]CORSPolicy `yaml:"routes"`
+}
+
+type CORSPolicy struct {
+	AllowedOrigins   []string      `yaml:"allowed_origins"`
+	AllowedMethods   []string      `yaml:"allowed_methods"`
+	AllowedHeaders   []string      `yaml:"allowed_headers"`
+	ExposedHeaders   []string      `yaml:"exposed_headers"`
+	AllowCredentials bool          `yaml:"allow_credentials"`
+	MaxAge           time.Duration `yaml:"max_age"`
+}
+
+// SecurityHeaders are set with safe defaults for JSON APIs when unset, and
+// omitted when set to "-". Routes override the Content-Security-Policy per
+// path, keyed by the path as registered.
+type SecurityHeaders struct {
+	Enabled               bool              `yaml:"enabled"`
+	HSTS                  HSTS              `yaml:"hsts"`
+	ContentTypeOptions    string            `yaml:"content_type_options"`
+	FrameOptions          string            `yaml:"frame_options"`
+	ReferrerPolicy        string            `yaml:"referrer_policy"`
+	ContentSecurityPolicy string            `yaml:"content_security_policy"`
+	Routes                map[string]string `yaml:"routes"`
+}
+
+// HSTS is sent on the TLS listener only, with a MaxAge of a year by default.
+type HSTS struct {
+	Disabled          bool          `yaml:"disabled"`
+	MaxAge            time.Duration `yaml:"max_age"`
+	IncludeSubdomains bool          `yaml:"include_subdomains"`
+	Preload           bool          `yaml:"preload"`
+}
+
+// IPFilter rejects the clients in Deny, and the ones not in Allow when it's
+// set, both lists of CIDRs or IPs. Routes add a rule per route prefix, e.g.
+// "/admin", the longest matching one applying on top of the global one.
+type IPFilter struct {
+	Allow  []string                `yaml:"allow"`
+	Deny   []string                `yaml:"deny"`
+	Routes map[string]IPFilterRule `yaml:"routes"`
+}
+
+type IPFilterRule struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// Compression compresses the responses of at least MinSize bytes (1KB by
+// default) with one of the ContentTypes (DefaultCompressionContentTypes
+// by default, "text/*" matching any text type), at the flate Level, from 1
+// to 9, the default compression by default.
+type Compression struct {
+	Enabled      bool     `yaml:"enabled"`
+	Level        int      `yaml:"level"`
+	MinSize      int      `yaml:"min_size"`
+	ContentTypes []string `yaml:"content_types"`
+}
+
+// ETags tags the responses of the JSON GET routes, weakly when Weak is
+// set, to answer conditional requests. As If-Match never matches weak tags,
+// Weak turns off the If-Match checks of the JSON resources.
+type ETags struct {
+	Enabled bool `yaml:"enabled"`
+	Weak    bool `yaml:"weak"`
+}
+
+// ResponseCache caches the responses of the JSON GET routes in Routes,
+// keyed like Timeouts.Routes, keeping up to MaxEntries (1000 by default)
+// in memory. The changes of a JSON resource invalidate its responses.
+type ResponseCache struct {
+	Enabled    bool                 `yaml:"enabled"`
+	MaxEntries int                  `yaml:"max_entries"`
+	Routes     map[string]CacheRule `yaml:"routes"`
+}
+
+// CacheRule caches the responses for TTL, and serves them for up to
+// StaleWhileRevalidate more while refreshing them in the background. They
+// are cached per path and query, and per value of the VaryHeaders and
+// authenticated user when VaryByUser is set.
+type CacheRule struct {
+	TTL                  time.Duration `yaml:"ttl"`
+	StaleWhileRevalidate time.Duration `yaml:"stale_while_revalidate"`
+	VaryHeaders          []string      `yaml:"vary_headers"`
+	VaryByUser           bool          `yaml:"vary_by_user"`
+}
+
+// Idempotency replays the responses of the Routes, e.g. "POST /users", to
+// the retries with the same Idempotency-Key header, for TTL (24h by
+// default).
+type Idempotency struct {
+	Enabled bool          `yaml:"enabled"`
+	TTL     time.Duration `yaml:"ttl"`
+	Routes  []string      `yaml:"routes"`
 }
 
 func NewHTTPAuth(username, password string) HTTPAuth {
@@ -28,14 +321,61 @@ func NewHTTPAuth(username, password string) HTTPAuth {
 	}
 }
 
+// NewConfig reads the config at path, interpolating environment variables
+// and applying the `THRUSTER_*` environment overrides. The file can be YAML,
+// JSON or TOML, picked by its extension.
 func NewConfig(path string) (Config, error) {
-	config := Config{}
+	return NewConfigWithEnvPrefix(path, DefaultEnvPrefix)
+}
+
+func NewConfigWithEnvPrefix(path, prefix string) (Config, error) {
+	return loadConfig(path, prefix, false)
+}
+
+// NewStrictConfig works as NewConfig, but also rejects unknown YAML keys and
+// validates the result, reporting all the problems found at once.
+func NewStrictConfig(path string) (Config, error) {
+	return loadConfig(path, DefaultEnvPrefix, true)
+}
+
+// NewConfigFromReader works as NewConfig for configs that are not on disk.
+func NewConfigFromReader(reader io.Reader, format Format) (Config, error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return Config{}, err
+	}
+
+	return decodeConfig(data, format, DefaultEnvPrefix, false)
+}
+
+func loadConfig(path, prefix string, strict bool) (Config, error) {
 	data, err := ioutil.ReadFile(path)
 
 	if err != nil {
+		return Config{}, err
+	}
+
+	return decodeConfig(data, FormatFromPath(path), prefix, strict)
+}
+
+func decodeConfig(data []byte, format Format, prefix string, strict bool) (Config, error) {
+	config := Config{}
+	tree, err := decodeTree(data, format)
+	if err != nil {
+		return config, err
+	}
+
+	err = fromTree(tree, &config)
+	if err != nil {
+		return config, err
+	}
+
+	err = config.LoadEnv(prefix)
+	if err != nil || !strict {
 		return config, err
 	}
 
-	err = yaml.Unmarshal(data, &config)
-	return config, err
+	errs := unknownKeys(tree)
+	errs = append(errs, config.validate()...)
+	return config, errs.err()
 }
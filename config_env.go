@@ -0,0 +1,211 @@
+package thruster
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const DefaultEnvPrefix = "THRUSTER"
+
+var interpolationPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// Interpolate replaces `${VAR}` and `${VAR:-default}` references in data
+// with the value of the environment variable VAR. The default is used when
+// VAR is unset or empty. The config files are interpolated after they are
+// decoded, value by value, so the variables can't change their structure.
+func Interpolate(data []byte) []byte {
+	return interpolationPattern.ReplaceAllFunc(data, func(match []byte) []byte {
+		groups := interpolationPattern.FindSubmatch(match)
+		value := os.Getenv(string(groups[1]))
+		if value == "" && len(groups[2]) > 0 {
+			return groups[3]
+		}
+		return []byte(value)
+	})
+}
+
+// interpolateTree interpolates the strings of a decoded config. A value
+// that is a single reference takes the type of the variable's value when
+// it's a number or a boolean, e.g. `port: ${PORT}`.
+func interpolateTree(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		for key, item := range value {
+			value[key] = interpolateTree(item)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = interpolateTree(item)
+		}
+	case string:
+		if !interpolationPattern.MatchString(value) {
+			return value
+		}
+
+		interpolated := string(Interpolate([]byte(value)))
+		if interpolationPattern.FindString(value) == value {
+			return typedScalar(interpolated)
+		}
+		return interpolated
+	}
+	return value
+}
+
+// typedScalar returns value as a number or a boolean when YAML would read
+// it as one, and as the string otherwise.
+func typedScalar(value string) interface{} {
+	var scalar interface{}
+	if err := yaml.Unmarshal([]byte(value), &scalar); err != nil {
+		return value
+	}
+
+	switch scalar.(type) {
+	case int, int64, uint64, float64, bool:
+		return scalar
+	}
+	return value
+}
+
+// LoadEnv overrides the config fields with the environment variables named
+// after their yaml keys, e.g. `THRUSTER_PORT` or `THRUSTER_EXPLORER_PATH` for
+// the prefix "THRUSTER". A variable suffixed with `_FILE` is read as the path of
+// a file holding the value, as Docker and Kubernetes secrets are mounted.
+func (c *Config) LoadEnv(prefix string) error {
+	err := loadEnv(reflect.ValueOf(c).Elem(), strings.ToUpper(prefix))
+	if err != nil {
+		return err
+	}
+
+	return c.loadSecretFiles()
+}
+
+func loadEnv(value reflect.Value, prefix string) error {
+	valueType := value.Type()
+
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+		key := yamlKey(field)
+		if key == "" {
+			continue
+		}
+
+		name := strings.ToUpper(key)
+		if prefix != "" {
+			name = prefix + "_" + name
+		}
+
+		if field.Type.Kind() == reflect.Struct {
+			if err := loadEnv(value.Field(i), name); err != nil {
+				return err
+			}
+			continue
+		}
+
+		envValue, found, err := lookupEnv(name)
+		if err != nil {
+			return err
+		}
+
+		if !found {
+			continue
+		}
+
+		if err := setFromString(value.Field(i), envValue); err != nil {
+			return fmt.Errorf("invalid value for %s: %s", name, err)
+		}
+	}
+
+	return nil
+}
+
+func lookupEnv(name string) (string, bool, error) {
+	if path := os.Getenv(name + "_FILE"); path != "" {
+		value, err := readSecretFile(path)
+		return value, err == nil, err
+	}
+
+	value, found := os.LookupEnv(name)
+	return value, found, nil
+}
+
+func setFromString(field reflect.Value, value string) error {
+	if field.Kind() == reflect.String {
+		field.SetString(value)
+		return nil
+	}
+
+	newValue := reflect.New(field.Type())
+	if err := yaml.Unmarshal([]byte(value), newValue.Interface()); err != nil {
+		return err
+	}
+
+	field.Set(newValue.Elem())
+	return nil
+}
+
+func (c *Config) loadSecretFiles() error {
+	for _, secret := range []struct {
+		path  string
+		value *string
+	}{
+		{c.CertificateFile, &c.Certificate},
+		{c.PublicKeyFile, &c.PublicKey},
+	} {
+		if secret.path == "" {
+			continue
+		}
+
+		value, err := readSecretFile(secret.path)
+		if err != nil {
+			return err
+		}
+		*secret.value = value
+	}
+
+	for _, accounts := range [][]HTTPAuth{c.HTTPAuth, c.Admin.HTTPAuth} {
+		for i, auth := range accounts {
+			if auth.PasswordFile == "" {
+				continue
+			}
+
+			password, err := readSecretFile(auth.PasswordFile)
+			if err != nil {
+				return err
+			}
+			accounts[i].Password = password
+		}
+	}
+
+	return nil
+}
+
+func readSecretFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func yamlKey(field reflect.StructField) string {
+	if field.PkgPath != "" {
+		return ""
+	}
+
+	tag := strings.Split(field.Tag.Get("yaml"), ",")[0]
+	if tag == "-" {
+		return ""
+	}
+
+	if tag == "" {
+		return strings.ToLower(field.Name)
+	}
+	return tag
+}
@@ -0,0 +1,114 @@
+package thruster
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// Format is the encoding of a config file. The field names are the same in
+// all of them: the `yaml` tags of Config.
+type Format string
+
+const (
+	FormatYAML Format = "yaml"
+	FormatJSON Format = "json"
+	FormatTOML Format = "toml"
+)
+
+// FormatFromPath picks the format by the file extension, defaulting to YAML.
+func FormatFromPath(path string) Format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return FormatJSON
+	case ".toml":
+		return FormatTOML
+	}
+	return FormatYAML
+}
+
+func decodeTree(data []byte, format Format) (map[interface{}]interface{}, error) {
+	var tree interface{}
+
+	switch format {
+	case FormatYAML, "":
+		raw := map[interface{}]interface{}{}
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, err
+		}
+		tree = raw
+	case FormatJSON:
+		raw := map[string]interface{}{}
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, err
+		}
+		tree = integersFromJSON(raw)
+	case FormatTOML:
+		raw, err := decodeTOML(data)
+		if err != nil {
+			return nil, err
+		}
+		tree = raw
+	default:
+		return nil, fmt.Errorf("unknown config format %q", format)
+	}
+
+	return interpolateTree(normalizeTree(tree)).(map[interface{}]interface{}), nil
+}
+
+// integersFromJSON converts the whole numbers decoded by encoding/json as
+// float64 back into ints, so they can fill int fields.
+func integersFromJSON(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			value[key] = integersFromJSON(item)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = integersFromJSON(item)
+		}
+	case float64:
+		if value == math.Trunc(value) && math.Abs(value) < math.MaxInt32 {
+			return int(value)
+		}
+	}
+	return value
+}
+
+// decodeTOML decodes a TOML config into a tree like the JSON one.
+func decodeTOML(data []byte) (map[string]interface{}, error) {
+	raw := map[string]interface{}{}
+	if _, err := toml.Decode(string(data), &raw); err != nil {
+		return nil, err
+	}
+	return tablesFromTOML(raw).(map[string]interface{}), nil
+}
+
+// tablesFromTOML converts the arrays of tables, decoded as
+// []map[string]interface{}, into []interface{}, as the other formats
+// decode them.
+func tablesFromTOML(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			value[key] = tablesFromTOML(item)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = tablesFromTOML(item)
+		}
+	case []map[string]interface{}:
+		items := make([]interface{}, len(value))
+		for i, item := range value {
+			items[i] = tablesFromTOML(item)
+		}
+		return items
+	}
+	return value
+}
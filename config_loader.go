@@ -0,0 +1,245 @@
+package thruster
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const redacted = "[REDACTED]"
+
+// Loader builds a Config from several sources, each one deep merged on top of
+// the previous: Defaults, the file at Path, its profile variant
+// (`config.<profile>.yml`), the environment variables and Overrides.
+type Loader struct {
+	Defaults  Config
+	Path      string
+	Profile   string
+	EnvPrefix string
+	Overrides map[string]interface{}
+	Strict    bool
+
+	// Format forces the format of the files, otherwise picked by their
+	// extension.
+	Format Format
+}
+
+// NewLoader returns a Loader for path with the built-in defaults, reading
+// the profile from `THRUSTER_ENV`.
+func NewLoader(path string) *Loader {
+	return &Loader{
+		Defaults:  DefaultConfig(),
+		Path:      path,
+		Profile:   os.Getenv(DefaultEnvPrefix + "_ENV"),
+		EnvPrefix: DefaultEnvPrefix,
+	}
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Hostname: "localhost",
+		Port:     8080,
+		Explorer: Explorer{
+			Path: defaultExplorerPath,
+		},
+	}
+}
+
+func (l *Loader) Load() (Config, error) {
+	config := Config{}
+	errs := ValidationErrors{}
+
+	tree, err := toTree(l.Defaults)
+	if err != nil {
+		return config, err
+	}
+
+	for _, path := range l.files() {
+		data, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) && path != l.Path {
+			continue
+		}
+		if err != nil {
+			return config, err
+		}
+
+		format := l.Format
+		if format == "" {
+			format = FormatFromPath(path)
+		}
+
+		fileTree, err := decodeTree(data, format)
+		if err != nil {
+			return config, fmt.Errorf("%s: %s", path, err)
+		}
+
+		if l.Strict {
+			errs = append(errs, unknownKeys(fileTree)...)
+		}
+
+		mergeTrees(tree, fileTree)
+	}
+
+	if err := fromTree(tree, &config); err != nil {
+		return config, err
+	}
+
+	if l.EnvPrefix != "" {
+		if err := config.LoadEnv(l.EnvPrefix); err != nil {
+			return config, err
+		}
+	}
+
+	if len(l.Overrides) > 0 {
+		tree, err := toTree(config)
+		if err != nil {
+			return config, err
+		}
+
+		mergeTrees(tree, normalizeTree(l.Overrides).(map[interface{}]interface{}))
+		config = Config{}
+		if err := fromTree(tree, &config); err != nil {
+			return config, err
+		}
+	}
+
+	if l.Strict {
+		errs = append(errs, config.validate()...)
+	}
+
+	return config, errs.err()
+}
+
+func (l *Loader) files() []string {
+	if l.Path == "" {
+		return nil
+	}
+
+	files := []string{l.Path}
+	if l.Profile != "" {
+		extension := filepath.Ext(l.Path)
+		files = append(files, strings.TrimSuffix(l.Path, extension)+"."+l.Profile+extension)
+	}
+
+	return files
+}
+
+// Redacted returns a copy of the config with its secrets replaced, safe to
+// be logged.
+func (c Config) Redacted() Config {
+	value := reflect.New(reflect.TypeOf(c)).Elem()
+	value.Set(reflect.ValueOf(c))
+	redact(value)
+
+	return value.Interface().(Config)
+}
+
+// Dump returns the YAML representation of the redacted config.
+func (c Config) Dump() ([]byte, error) {
+	return yaml.Marshal(c.Redacted())
+}
+
+func redact(value reflect.Value) {
+	switch value.Kind() {
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+
+			if field.Type.Kind() == reflect.String {
+				redactString(value.Field(i), field.Tag.Get("secret"))
+				continue
+			}
+			redact(value.Field(i))
+		}
+
+	case reflect.Slice:
+		if value.IsNil() {
+			return
+		}
+
+		copied := reflect.MakeSlice(value.Type(), value.Len(), value.Len())
+		reflect.Copy(copied, value)
+		for i := 0; i < copied.Len(); i++ {
+			redact(copied.Index(i))
+		}
+		value.Set(copied)
+	}
+}
+
+// redactString hides secret values. Fields tagged `secret:"pem"` accept
+// either a path or an inlined PEM block, and only the latter is hidden.
+func redactString(value reflect.Value, secret string) {
+	switch {
+	case value.String() == "":
+	case secret == "true":
+		value.SetString(redacted)
+	case secret == "pem" && isPEM(value.String()):
+		value.SetString(redacted)
+	}
+}
+
+func toTree(config Config) (map[interface{}]interface{}, error) {
+	tree := map[interface{}]interface{}{}
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	err = yaml.Unmarshal(data, &tree)
+	return tree, err
+}
+
+func fromTree(tree map[interface{}]interface{}, config *Config) error {
+	data, err := yaml.Marshal(tree)
+	if err != nil {
+		return err
+	}
+
+	return yaml.Unmarshal(data, config)
+}
+
+// mergeTrees deep merges src into dst. Nested sections are merged key by key,
+// any other value, lists included, is replaced.
+func mergeTrees(dst, src map[interface{}]interface{}) {
+	for key, value := range src {
+		srcSection, srcIsMap := value.(map[interface{}]interface{})
+		dstSection, dstIsMap := dst[key].(map[interface{}]interface{})
+		if srcIsMap && dstIsMap {
+			mergeTrees(dstSection, srcSection)
+			continue
+		}
+		dst[key] = value
+	}
+}
+
+func normalizeTree(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		tree := map[interface{}]interface{}{}
+		for key, item := range value {
+			tree[key] = normalizeTree(item)
+		}
+		return tree
+	case map[interface{}]interface{}:
+		tree := map[interface{}]interface{}{}
+		for key, item := range value {
+			tree[fmt.Sprint(key)] = normalizeTree(item)
+		}
+		return tree
+	case []interface{}:
+		items := make([]interface{}, len(value))
+		for i, item := range value {
+			items[i] = normalizeTree(item)
+		}
+		return items
+	}
+	return value
+}
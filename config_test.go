@@ -1,6 +1,11 @@
 package thruster_test
 
 import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/tscolari/thruster"
@@ -24,6 +29,319 @@ var _ = Describe("Config", func() {
 		})
 	})
 
+	Describe("formats", func() {
+		expectSampleConfig := func(config thruster.Config) {
+			Expect(config.Hostname).To(Equal("localhost"))
+			Expect(config.Port).To(Equal(8888))
+			Expect(config.TLS).To(Equal(true))
+			Expect(config.Certificate).To(Equal("/etc/certificate1"))
+			Expect(config.PublicKey).To(Equal("/etc/public_key"))
+			Expect(config.HTTPAuth).To(Equal([]thruster.HTTPAuth{
+				thruster.HTTPAuth{Username: "admin", Password: "12345"},
+				thruster.HTTPAuth{Username: "user1", Password: "6666"},
+			}))
+		}
+
+		It("reads JSON files", func() {
+			config, err := thruster.NewConfig("fixtures/sample.config.json")
+			Expect(err).ToNot(HaveOccurred())
+			expectSampleConfig(config)
+		})
+
+		It("reads TOML files", func() {
+			config, err := thruster.NewConfig("fixtures/sample.config.toml")
+			Expect(err).ToNot(HaveOccurred())
+			expectSampleConfig(config)
+		})
+
+		It("picks the format by the file extension", func() {
+			Expect(thruster.FormatFromPath("config.yml")).To(Equal(thruster.FormatYAML))
+			Expect(thruster.FormatFromPath("config.JSON")).To(Equal(thruster.FormatJSON))
+			Expect(thruster.FormatFromPath("config.toml")).To(Equal(thruster.FormatTOML))
+			Expect(thruster.FormatFromPath("config")).To(Equal(thruster.FormatYAML))
+		})
+
+		It("validates every format the same way", func() {
+			loader := thruster.NewLoader("fixtures/sample.config.toml")
+			loader.Profile = ""
+			loader.Strict = true
+
+			_, err := loader.Load()
+			Expect(err).To(MatchError(ContainSubstring("certificate: can't be read")))
+		})
+
+		Describe("NewConfigFromReader", func() {
+			It("reads the config in the given format", func() {
+				config, err := thruster.NewConfigFromReader(strings.NewReader(`{"hostname": "example.com", "port": 80}`), thruster.FormatJSON)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(config.Hostname).To(Equal("example.com"))
+				Expect(config.Port).To(Equal(80))
+			})
+
+			It("fails on unknown formats", func() {
+				_, err := thruster.NewConfigFromReader(strings.NewReader(""), thruster.Format("ini"))
+				Expect(err).To(MatchError(`unknown config format "ini"`))
+			})
+
+			It("supports the TOML syntax used by configs", func() {
+				toml := `# comment
+hostname = 'example.com' # trailing comment
+port = 8_080
+explorer = { enabled = true, path = "/docs" }
+http_auth = [
+  { username = "admin", password = "p\u00e4ss\"word" },
+]
+certificate = """
+-----BEGIN CERTIFICATE-----
+MIID
+-----END CERTIFICATE-----"""
+
+[metrics]
+path = "/stats"
+`
+				config, err := thruster.NewConfigFromReader(strings.NewReader(toml), thruster.FormatTOML)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(config.Hostname).To(Equal("example.com"))
+				Expect(config.Port).To(Equal(8080))
+				Expect(config.Explorer).To(Equal(thruster.Explorer{Enabled: true, Path: "/docs"}))
+				Expect(config.HTTPAuth).To(Equal([]thruster.HTTPAuth{thruster.NewHTTPAuth("admin", "päss\"word")}))
+				Expect(config.Certificate).To(Equal("-----BEGIN CERTIFICATE-----\nMIID\n-----END CERTIFICATE-----"))
+				Expect(config.Metrics.Path).To(Equal("/stats"))
+			})
+
+			It("supports the whole TOML syntax", func() {
+				toml := "port = 0x1F90\nupdated = 1979-05-27T07:32:00Z\n"
+				config, err := thruster.NewConfigFromReader(strings.NewReader(toml), thruster.FormatTOML)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(config.Port).To(Equal(8080))
+			})
+
+			It("rejects invalid TOML", func() {
+				for _, toml := range []string{
+					"port = 080\n",
+					"[explorer]\n[explorer]\n",
+					"hostname = \"a\\ b\"\n",
+				} {
+					_, err := thruster.NewConfigFromReader(strings.NewReader(toml), thruster.FormatTOML)
+					Expect(err).To(HaveOccurred(), toml)
+				}
+			})
+
+			It("reports truncated TOML as an error", func() {
+				for _, toml := range []string{
+					"[", "[[", "[a", "port =", "a.", "a", "x = {", "x = { a = 1,", "x = [1,", "x = [",
+					`x = "\`, `x = '''`, `x = """\`, `x = "\u12`,
+				} {
+					_, err := thruster.NewConfigFromReader(strings.NewReader(toml), thruster.FormatTOML)
+					Expect(err).To(HaveOccurred(), toml)
+				}
+			})
+
+			It("doesn't panic on half-saved files", func() {
+				data, err := ioutil.ReadFile("fixtures/sample.config.toml")
+				Expect(err).ToNot(HaveOccurred())
+
+				for i := range data {
+					Expect(func() {
+						thruster.NewConfigFromReader(bytes.NewReader(data[:i]), thruster.FormatTOML)
+					}).ToNot(Panic(), string(data[:i]))
+				}
+			})
+
+			It("reports TOML syntax errors with their line", func() {
+				_, err := thruster.NewConfigFromReader(strings.NewReader("port = 80\nhostname = \"unterminated\n"), thruster.FormatTOML)
+				Expect(err).To(MatchError(HavePrefix("toml: line 2")))
+			})
+		})
+	})
+
+	Describe("environment variables", func() {
+		var envNames []string
+
+		setEnv := func(name, value string) {
+			Expect(os.Setenv(name, value)).To(Succeed())
+			envNames = append(envNames, name)
+		}
+
+		AfterEach(func() {
+			for _, name := range envNames {
+				os.Unsetenv(name)
+			}
+			envNames = nil
+		})
+
+		It("overrides the config fields", func() {
+			setEnv("THRUSTER_PORT", "3000")
+			setEnv("THRUSTER_TLS", "false")
+			setEnv("THRUSTER_CERTIFICATE", "/etc/other")
+			setEnv("THRUSTER_EXPLORER_PATH", "/docs")
+
+			config, err := thruster.NewConfig("fixtures/sample.config.yaml")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Hostname).To(Equal("localhost"))
+			Expect(config.Port).To(Equal(3000))
+			Expect(config.TLS).To(BeFalse())
+			Expect(config.Certificate).To(Equal("/etc/other"))
+			Expect(config.Explorer.Path).To(Equal("/docs"))
+		})
+
+		It("parses list values as YAML", func() {
+			setEnv("THRUSTER_HTTP_AUTH", "[{username: root, password: toor}]")
+
+			config, err := thruster.NewConfig("fixtures/sample.config.yaml")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.HTTPAuth).To(Equal([]thruster.HTTPAuth{
+				thruster.NewHTTPAuth("root", "toor"),
+			}))
+		})
+
+		It("uses the given prefix", func() {
+			setEnv("THRUSTER_PORT", "3000")
+			setEnv("MYAPP_PORT", "4000")
+
+			config, err := thruster.NewConfigWithEnvPrefix("fixtures/sample.config.yaml", "myapp")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Port).To(Equal(4000))
+		})
+
+		It("reads values from *_FILE paths", func() {
+			setEnv("THRUSTER_PUBLIC_KEY_FILE", "fixtures/secret.txt")
+
+			config, err := thruster.NewConfig("fixtures/sample.config.yaml")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.PublicKey).To(Equal("s3cr3t"))
+		})
+
+		It("fails when a *_FILE path can't be read", func() {
+			setEnv("THRUSTER_PUBLIC_KEY_FILE", "fixtures/missing.txt")
+
+			_, err := thruster.NewConfig("fixtures/sample.config.yaml")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("fails on values of the wrong type", func() {
+			setEnv("THRUSTER_PORT", "not-a-number")
+
+			_, err := thruster.NewConfig("fixtures/sample.config.yaml")
+			Expect(err).To(MatchError(ContainSubstring("THRUSTER_PORT")))
+		})
+
+		It("interpolates ${VAR} and ${VAR:-default} in the YAML", func() {
+			setEnv("THRUSTER_TEST_HOSTNAME", "example.com")
+
+			config, err := thruster.NewConfig("fixtures/interpolated.config.yaml")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Hostname).To(Equal("example.com"))
+			Expect(config.Port).To(Equal(9999))
+		})
+
+		It("interpolates values as they are, without changing the document", func() {
+			setEnv("THRUSTER_TEST_HOSTNAME", "evil\"\nport: 1 # comment")
+
+			config, err := thruster.NewConfigFromReader(strings.NewReader(`{"hostname": "${THRUSTER_TEST_HOSTNAME}", "port": "${THRUSTER_TEST_PORT:-9999}"}`), thruster.FormatJSON)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Hostname).To(Equal("evil\"\nport: 1 # comment"))
+			Expect(config.Port).To(Equal(9999))
+		})
+
+		It("loads the certificate and key from certificate_file and public_key_file", func() {
+			config, err := thruster.NewConfigFromReader(strings.NewReader(
+				"certificate_file: fixtures/server.crt\npublic_key_file: fixtures/server.key\n"), thruster.FormatYAML)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Certificate).To(HavePrefix("-----BEGIN CERTIFICATE-----"))
+			Expect(config.PublicKey).To(ContainSubstring("PRIVATE KEY-----"))
+		})
+
+		It("loads HTTP auth passwords from password_file", func() {
+			config, err := thruster.NewConfig("fixtures/interpolated.config.yaml")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.HTTPAuth[0].Password).To(Equal("s3cr3t"))
+		})
+	})
+
+	Describe("Validate", func() {
+		var config thruster.Config
+
+		BeforeEach(func() {
+			config = thruster.Config{
+				Hostname:    "localhost",
+				Port:        8080,
+				TLS:         true,
+				Certificate: "fixtures/server.crt",
+				PublicKey:   "fixtures/server.key",
+				HTTPAuth:    []thruster.HTTPAuth{thruster.NewHTTPAuth("admin", "12345")},
+			}
+		})
+
+		It("accepts a valid config", func() {
+			Expect(config.Validate()).To(Succeed())
+		})
+
+		It("accepts inline certificates", func() {
+			config.Certificate = "-----BEGIN CERTIFICATE-----\n..."
+			Expect(config.Validate()).To(Succeed())
+		})
+
+		It("accepts port 0, for a port picked by the system", func() {
+			config.Port = 0
+			Expect(config.Validate()).To(Succeed())
+		})
+
+		It("returns all the problems at once, with their field paths", func() {
+			config.Port = -1
+			config.Certificate = ""
+			config.PublicKey = "fixtures/missing.key"
+			config.HTTPAuth = append(config.HTTPAuth, thruster.NewHTTPAuth("admin", ""))
+
+			err := config.Validate()
+			Expect(err).To(HaveOccurred())
+
+			errs, ok := err.(thruster.ValidationErrors)
+			Expect(ok).To(BeTrue())
+
+			fields := []string{}
+			for _, e := range errs {
+				fields = append(fields, e.Field)
+			}
+			Expect(fields).To(Equal([]string{
+				"port",
+				"certificate",
+				"public_key",
+				"http_auth[1].username",
+				"http_auth[1].password",
+			}))
+			Expect(err.Error()).To(ContainSubstring(`http_auth[1].username: duplicates http_auth[0].username "admin"`))
+		})
+
+		It("doesn't require certificates without TLS", func() {
+			config.TLS = false
+			config.Certificate = ""
+			config.PublicKey = ""
+			Expect(config.Validate()).To(Succeed())
+		})
+	})
+
+	Describe("NewStrictConfig", func() {
+		It("loads a valid config", func() {
+			config, err := thruster.NewStrictConfig("fixtures/interpolated.config.yaml")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Port).To(Equal(9999))
+		})
+
+		It("reports unknown keys along with the validation errors", func() {
+			_, err := thruster.NewStrictConfig("fixtures/invalid.config.yaml")
+			Expect(err).To(HaveOccurred())
+
+			errs, ok := err.(thruster.ValidationErrors)
+			Expect(ok).To(BeTrue())
+			Expect(errs).To(ContainElement(thruster.ValidationError{Field: "verbose", Message: "unknown field"}))
+			Expect(errs).To(ContainElement(thruster.ValidationError{Field: "http_auth[1].role", Message: "unknown field"}))
+			Expect(errs).To(ContainElement(thruster.ValidationError{Field: "rate_limit.routes[GET /users].brust", Message: "unknown field"}))
+			Expect(errs).To(ContainElement(thruster.ValidationError{Field: "port", Message: "must be between 0 and 65535, got 70000"}))
+			Expect(errs).To(ContainElement(thruster.ValidationError{Field: "certificate", Message: "is required when tls is enabled"}))
+		})
+	})
+
 	Describe("NewHTTPAuth", func() {
 		It("returns a correct HTTPAuth object", func() {
 			httpAuth := thruster.NewHTTPAuth("user", "passwd")
@@ -0,0 +1,526 @@
+package thruster
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationErrors holds every problem found in a config, so they can all be
+// fixed at once.
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return "invalid config:\n  " + strings.Join(messages, "\n  ")
+}
+
+func (e *ValidationErrors) add(field, format string, args ...interface{}) {
+	*e = append(*e, ValidationError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (e ValidationErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Validate checks the config for values that would make the server fail or
+// misbehave. The returned error, if any, is a ValidationErrors.
+func (c Config) Validate() error {
+	return c.validate().err()
+}
+
+func (c Config) validate() ValidationErrors {
+	errs := ValidationErrors{}
+
+	// Port 0 listens on a port picked by the system.
+	if c.Port < 0 || c.Port > 65535 {
+		errs.add("port", "must be between 0 and 65535, got %d", c.Port)
+	}
+
+	if c.TLS {
+		validatePEM(&errs, "certificate", c.Certificate)
+		validatePEM(&errs, "public_key", c.PublicKey)
+	}
+
+	validateHTTPAuth(&errs, "http_auth", c.HTTPAuth)
+
+	if c.Explorer.Path != "" && !strings.HasPrefix(c.Explorer.Path, "/") {
+		errs.add("explorer.path", "must start with '/', got %q", c.Explorer.Path)
+	}
+
+	if c.Metrics.Path != "" && !strings.HasPrefix(c.Metrics.Path, "/") {
+		errs.add("metrics.path", "must start with '/', got %q", c.Metrics.Path)
+	}
+
+	if c.Health.LivenessPath != "" && !strings.HasPrefix(c.Health.LivenessPath, "/") {
+		errs.add("health.liveness_path", "must start with '/', got %q", c.Health.LivenessPath)
+	}
+
+	if c.Health.ReadinessPath != "" && !strings.HasPrefix(c.Health.ReadinessPath, "/") {
+		errs.add("health.readiness_path", "must start with '/', got %q", c.Health.ReadinessPath)
+	}
+
+	if _, found := logLevels[c.LogLevel]; c.LogLevel != "" && !found {
+		errs.add("log_level", "must be one of info, warn or error, got %q", c.LogLevel)
+	}
+
+	if c.RequestID.MaxLength < 0 {
+		errs.add("request_id.max_length", "can't be negative")
+	}
+
+	c.Admin.validate(&errs)
+	c.Timeouts.validate(&errs)
+	c.Limits.validate(&errs)
+	c.RateLimit.validate(&errs)
+	c.Concurrency.validate(&errs)
+	c.CORS.validate(&errs)
+
+	validateCIDRs(&errs, "trusted_proxies", c.TrustedProxies)
+	c.IPFilter.validate(&errs)
+
+	c.Compression.validate(&errs)
+	c.ResponseCache.validate(&errs)
+	c.Idempotency.validate(&errs)
+	c.SecurityHeaders.validate(&errs)
+	c.AccessLog.validate(&errs)
+	c.Tracing.validate(&errs)
+	return errs
+}
+
+func validateHTTPAuth(errs *ValidationErrors, path string, accounts []HTTPAuth) {
+	usernames := map[string]int{}
+	for i, auth := range accounts {
+		field := fmt.Sprintf("%s[%d]", path, i)
+		if auth.Username == "" {
+			errs.add(field+".username", "can't be empty")
+		} else if first, found := usernames[auth.Username]; found {
+			errs.add(field+".username", "duplicates %s[%d].username %q", path, first, auth.Username)
+		} else {
+			usernames[auth.Username] = i
+		}
+
+		if auth.Password == "" {
+			errs.add(field+".password", "can't be empty")
+		}
+	}
+}
+
+func (a Admin) validate(errs *ValidationErrors) {
+	if !a.Enabled {
+		return
+	}
+
+	if a.Prefix != "" && !strings.HasPrefix(a.Prefix, "/") {
+		errs.add("admin.prefix", "must start with '/', got %q", a.Prefix)
+	} else if a.Prefix != "" && strings.Trim(a.Prefix, "/") == "" {
+		// The endpoints are mounted at prefix/*path, which would take every
+		// route.
+		errs.add("admin.prefix", "can't be the root path")
+	}
+
+	if len(a.HTTPAuth) == 0 {
+		errs.add("admin.http_auth", "can't be empty")
+	}
+
+	validateHTTPAuth(errs, "admin.http_auth", a.HTTPAuth)
+}
+
+func (t Timeouts) validate(errs *ValidationErrors) {
+	durations := map[string]time.Duration{
+		"timeouts.read":        t.Read,
+		"timeouts.read_header": t.ReadHeader,
+		"timeouts.write":       t.Write,
+		"timeouts.idle":        t.Idle,
+		"timeouts.handler":     t.Handler,
+	}
+	for key, timeout := range t.Routes {
+		validateRouteKey(errs, "timeouts.routes", key)
+		durations[fmt.Sprintf("timeouts.routes[%s]", key)] = timeout
+	}
+
+	fields := make([]string, 0, len(durations))
+	for field := range durations {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		if durations[field] < 0 {
+			errs.add(field, "can't be negative")
+		}
+	}
+}
+
+func (l Limits) validate(errs *ValidationErrors) {
+	if l.MaxBodyBytes < 0 {
+		errs.add("limits.max_body_bytes", "can't be negative")
+	}
+
+	if l.MaxDecompressedBytes < 0 {
+		errs.add("limits.max_decompressed_bytes", "can't be negative")
+	}
+
+	if l.MaxHeaderBytes < 0 {
+		errs.add("limits.max_header_bytes", "can't be negative")
+	}
+
+	keys := make([]string, 0, len(l.Routes))
+	for key := range l.Routes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		validateRouteKey(errs, "limits.routes", key)
+		if l.Routes[key] < 0 {
+			errs.add(fmt.Sprintf("limits.routes[%s]", key), "can't be negative")
+		}
+	}
+}
+
+func (r RateLimit) validate(errs *ValidationErrors) {
+	rules := map[string]RateLimitRule{
+		"rate_limit": {Requests: r.Requests, Period: r.Period, Burst: r.Burst, Key: r.Key},
+	}
+	for key, rule := range r.Routes {
+		validateRouteKey(errs, "rate_limit.routes", key)
+		rules[fmt.Sprintf("rate_limit.routes[%s]", key)] = rule
+	}
+
+	fields := make([]string, 0, len(rules))
+	for field := range rules {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		rule := rules[field]
+		if rule.Requests < 0 {
+			errs.add(field+".requests", "can't be negative")
+		}
+		if rule.Period < 0 {
+			errs.add(field+".period", "can't be negative")
+		}
+		if rule.Burst < 0 {
+			errs.add(field+".burst", "can't be negative")
+		}
+
+		switch {
+		case rule.Key == "", rule.Key == RateLimitByIP, rule.Key == RateLimitByUser, rule.Key == RateLimitByRoute:
+		case strings.HasPrefix(rule.Key, RateLimitByHeader) && rule.Key != RateLimitByHeader:
+		default:
+			errs.add(field+".key", "must be ip, user, route or header:<name>, got %q", rule.Key)
+		}
+	}
+}
+
+func (c Concurrency) validate(errs *ValidationErrors) {
+	if c.MaxInFlight < 0 {
+		errs.add("concurrency.max_in_flight", "can't be negative")
+	}
+
+	if c.MaxQueue < 0 {
+		errs.add("concurrency.max_queue", "can't be negative")
+	}
+
+	if c.QueueTimeout < 0 {
+		errs.add("concurrency.queue_timeout", "can't be negative")
+	}
+
+	keys := make([]string, 0, len(c.Routes))
+	for key := range c.Routes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		validateRouteKey(errs, "concurrency.routes", key)
+		if c.Routes[key] < 0 {
+			errs.add(fmt.Sprintf("concurrency.routes[%s]", key), "can't be negative")
+		}
+	}
+
+	if c.Adaptive.Enabled && c.Adaptive.TargetLatency <= 0 {
+		errs.add("concurrency.adaptive.target_latency", "is required")
+	}
+}
+
+func (c CORS) validate(errs *ValidationErrors) {
+	validateCORSOrigins(errs, "cors.allowed_origins", c.AllowedOrigins, c.AllowCredentials)
+
+	paths := make([]string, 0, len(c.Routes))
+	for path := range c.Routes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		if !strings.HasPrefix(path, "/") {
+			errs.add("cors.routes", "keys must be paths starting with '/', got %q", path)
+		}
+		policy := c.Routes[path]
+		validateCORSOrigins(errs, fmt.Sprintf("cors.routes[%s].allowed_origins", path), policy.AllowedOrigins, policy.AllowCredentials)
+	}
+}
+
+// validateCORSOrigins rejects "*" with credentials, as browsers do, rather
+// than allowing every origin with them.
+func validateCORSOrigins(errs *ValidationErrors, field string, origins []string, credentials bool) {
+	for i, origin := range origins {
+		switch {
+		case origin == "*" && credentials:
+			errs.add(fmt.Sprintf("%s[%d]", field, i), "can't be \"*\" with allow_credentials")
+		case origin != "*" && strings.Contains(origin, "*") && !strings.Contains(origin, "://*."):
+			errs.add(fmt.Sprintf("%s[%d]", field, i), "wildcards must be a subdomain, e.g. https://*.example.com, got %q", origin)
+		}
+	}
+}
+
+func (f IPFilter) validate(errs *ValidationErrors) {
+	validateCIDRs(errs, "ip_filter.allow", f.Allow)
+	validateCIDRs(errs, "ip_filter.deny", f.Deny)
+
+	prefixes := make([]string, 0, len(f.Routes))
+	for prefix := range f.Routes {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	for _, prefix := range prefixes {
+		if !strings.HasPrefix(prefix, "/") {
+			errs.add("ip_filter.routes", "keys must be paths starting with '/', got %q", prefix)
+		}
+		validateCIDRs(errs, fmt.Sprintf("ip_filter.routes[%s].allow", prefix), f.Routes[prefix].Allow)
+		validateCIDRs(errs, fmt.Sprintf("ip_filter.routes[%s].deny", prefix), f.Routes[prefix].Deny)
+	}
+}
+
+func validateCIDRs(errs *ValidationErrors, field string, values []string) {
+	for i, value := range values {
+		if _, err := parseCIDR(value); err != nil {
+			errs.add(fmt.Sprintf("%s[%d]", field, i), "must be a CIDR or an IP, got %q", value)
+		}
+	}
+}
+
+// validateRouteKey checks the "METHOD /path" keys of per-route settings.
+func validateRouteKey(errs *ValidationErrors, field, key string) {
+	fields := strings.Fields(key)
+	if len(fields) != 2 || !strings.HasPrefix(fields[1], "/") {
+		errs.add(field, "keys must be \"METHOD /path\", got %q", key)
+	}
+}
+
+func (c Compression) validate(errs *ValidationErrors) {
+	if c.Level < 0 || c.Level > 9 {
+		errs.add("compression.level", "must be between 1 and 9, got %d", c.Level)
+	}
+
+	if c.MinSize < 0 {
+		errs.add("compression.min_size", "can't be negative")
+	}
+}
+
+func (r ResponseCache) validate(errs *ValidationErrors) {
+	if r.MaxEntries < 0 {
+		errs.add("response_cache.max_entries", "can't be negative")
+	}
+
+	keys := make([]string, 0, len(r.Routes))
+	for key := range r.Routes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		validateRouteKey(errs, "response_cache.routes", key)
+		if !strings.HasPrefix(key, GET+" ") {
+			errs.add("response_cache.routes", "only GET routes are cached, got %q", key)
+		}
+
+		field := fmt.Sprintf("response_cache.routes[%s]", key)
+		rule := r.Routes[key]
+		if rule.TTL <= 0 {
+			errs.add(field+".ttl", "must be positive")
+		}
+		if rule.StaleWhileRevalidate < 0 {
+			errs.add(field+".stale_while_revalidate", "can't be negative")
+		}
+	}
+}
+
+func (i Idempotency) validate(errs *ValidationErrors) {
+	if i.TTL < 0 {
+		errs.add("idempotency.ttl", "can't be negative")
+	}
+
+	for _, key := range i.Routes {
+		validateRouteKey(errs, "idempotency.routes", key)
+	}
+}
+
+func (h SecurityHeaders) validate(errs *ValidationErrors) {
+	paths := make([]string, 0, len(h.Routes))
+	for path := range h.Routes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		if !strings.HasPrefix(path, "/") {
+			errs.add("security_headers.routes", "keys must be paths starting with '/', got %q", path)
+		}
+	}
+}
+
+func (a AccessLog) validate(errs *ValidationErrors) {
+	switch a.Format {
+	case "", LogFormatJSON, LogFormatLogfmt, LogFormatCombined:
+	default:
+		errs.add("access_log.format", "must be one of json, logfmt or combined, got %q", a.Format)
+	}
+
+	for i, field := range a.Fields {
+		if _, _, ok := (accessLogEntry{}).value(field); !ok {
+			errs.add(fmt.Sprintf("access_log.fields[%d]", i), "unknown field %q", field)
+		}
+	}
+
+	if a.SampleRate < 0 || a.SampleRate > 1 {
+		errs.add("access_log.sample_rate", "must be between 0 and 1, got %v", a.SampleRate)
+	}
+
+	if a.MaxSize < 0 {
+		errs.add("access_log.max_size", "can't be negative")
+	}
+}
+
+func (t Tracing) validate(errs *ValidationErrors) {
+	switch t.Exporter {
+	case "", "stdout", "otlp":
+	case "file":
+		if t.File == "" {
+			errs.add("tracing.file", "is required by the file exporter")
+		}
+	default:
+		errs.add("tracing.exporter", "must be one of stdout, file or otlp, got %q", t.Exporter)
+	}
+
+	if t.SampleRate < 0 || t.SampleRate > 1 {
+		errs.add("tracing.sample_rate", "must be between 0 and 1, got %v", t.SampleRate)
+	}
+}
+
+func validatePEM(errs *ValidationErrors, field, value string) {
+	if value == "" {
+		errs.add(field, "is required when tls is enabled")
+		return
+	}
+
+	if isPEM(value) {
+		return
+	}
+
+	file, err := os.Open(value)
+	if err != nil {
+		errs.add(field, "can't be read: %s", err)
+		return
+	}
+	file.Close()
+}
+
+// unknownKeys returns an error for every key in the decoded config that
+// doesn't map to a Config field.
+func unknownKeys(tree map[interface{}]interface{}) ValidationErrors {
+	errs := ValidationErrors{}
+	checkKeys(&errs, "", tree, reflect.TypeOf(Config{}))
+	return errs
+}
+
+func checkKeys(errs *ValidationErrors, path string, raw interface{}, valueType reflect.Type) {
+	switch valueType.Kind() {
+	case reflect.Struct:
+		fields, ok := raw.(map[interface{}]interface{})
+		if !ok {
+			return
+		}
+
+		known := map[string]reflect.Type{}
+		for i := 0; i < valueType.NumField(); i++ {
+			field := valueType.Field(i)
+			if key := yamlKey(field); key != "" {
+				known[key] = field.Type
+			}
+		}
+
+		names := []string{}
+		values := map[string]interface{}{}
+		for key, value := range fields {
+			name := fmt.Sprint(key)
+			names = append(names, name)
+			values[name] = value
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			fieldPath := name
+			if path != "" {
+				fieldPath = path + "." + name
+			}
+
+			fieldType, found := known[name]
+			if !found {
+				errs.add(fieldPath, "unknown field")
+				continue
+			}
+			checkKeys(errs, fieldPath, values[name], fieldType)
+		}
+
+	case reflect.Map:
+		entries, ok := raw.(map[interface{}]interface{})
+		if !ok {
+			return
+		}
+
+		names := []string{}
+		values := map[string]interface{}{}
+		for key, value := range entries {
+			name := fmt.Sprint(key)
+			names = append(names, name)
+			values[name] = value
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			checkKeys(errs, fmt.Sprintf("%s[%s]", path, name), values[name], valueType.Elem())
+		}
+
+	case reflect.Slice:
+		items, ok := raw.([]interface{})
+		if !ok {
+			return
+		}
+
+		for i, item := range items {
+			checkKeys(errs, fmt.Sprintf("%s[%d]", path, i), item, valueType.Elem())
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package thruster
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+const routeKey = "thruster.route"
+
+// CurrentRoute returns the route the request was routed by.
+func CurrentRoute(c *gin.Context) (Route, bool) {
+	if route, ok := c.Get(routeKey); ok {
+		return route.(Route), true
+	}
+	return Route{}, false
+}
+
+// RoutePath returns the path template the request was routed by, e.g.
+// `/users/:id`, or an empty string for unmatched requests.
+func RoutePath(c *gin.Context) string {
+	route, _ := CurrentRoute(c)
+	return route.Path
+}
+
+func setRoute(route Route) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(routeKey, route)
+	}
+}
+
+// AuthenticatedUser returns the HTTPAuth username the request was
+// authenticated with.
+func AuthenticatedUser(c *gin.Context) string {
+	if user, ok := c.Get(gin.AuthUserKey); ok {
+		return user.(string)
+	}
+	return ""
+}
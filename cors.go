@@ -0,0 +1,181 @@
+package thruster
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var defaultCORSHeaders = []string{
+	"Accept", "Authorization", "Content-Type",
+	"If-Match", "If-None-Match", "Idempotency-Key",
+}
+
+var defaultCORSExposedHeaders = []string{
+	"ETag", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset",
+	"RateLimit-Policy", "Retry-After",
+}
+
+// policy returns the CORS policy of the route path, the route overrides
+// replacing the default one.
+func (c CORS) policy(path string) CORSPolicy {
+	if policy, found := c.Routes[path]; found {
+		return policy
+	}
+
+	return CORSPolicy{
+		AllowedOrigins:   c.AllowedOrigins,
+		AllowedMethods:   c.AllowedMethods,
+		AllowedHeaders:   c.AllowedHeaders,
+		ExposedHeaders:   c.ExposedHeaders,
+		AllowCredentials: c.AllowCredentials,
+		MaxAge:           c.MaxAge,
+	}
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for origin, or
+// an empty string when it's not allowed. Patterns can have a wildcard
+// subdomain, e.g. "https://*.example.com".
+func (p CORSPolicy) allowOrigin(origin string) string {
+	origin = strings.ToLower(origin)
+	for _, pattern := range p.AllowedOrigins {
+		pattern = strings.ToLower(pattern)
+		switch {
+		case pattern == "*":
+			return "*"
+		case pattern == origin:
+			return origin
+		case strings.Contains(pattern, "*."):
+			wildcard := strings.Index(pattern, "*")
+			prefix, suffix := pattern[:wildcard], pattern[wildcard+1:]
+			if len(origin) <= len(prefix)+len(suffix) || !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+				continue
+			}
+			if subdomain := origin[len(prefix) : len(origin)-len(suffix)]; !strings.ContainsAny(subdomain, "/:") {
+				return origin
+			}
+		}
+	}
+	return ""
+}
+
+func (p CORSPolicy) allowHeaders(requestID RequestIDConfig) []string {
+	if len(p.AllowedHeaders) == 0 {
+		return append(append([]string{}, defaultCORSHeaders...), requestID.header())
+	}
+	return p.AllowedHeaders
+}
+
+func (p CORSPolicy) exposeHeaders(requestID RequestIDConfig) []string {
+	if len(p.ExposedHeaders) == 0 {
+		return append(append([]string{}, defaultCORSExposedHeaders...), requestID.header())
+	}
+	return p.ExposedHeaders
+}
+
+// cors sets the CORS headers of the responses to allowed origins. The
+// preflight ones are set by preflight. The responses vary by origin even
+// without one, so caches don't serve them to the allowed origins.
+func (s *Server) cors(c *gin.Context) {
+	config := s.currentConfig()
+	if !config.CORS.Enabled || c.Request.Method == OPTIONS {
+		return
+	}
+
+	c.Writer.Header().Add("Vary", "Origin")
+	origin := c.Request.Header.Get("Origin")
+	if origin == "" {
+		return
+	}
+
+	policy := config.CORS.policy(RoutePath(c))
+	allowed := policy.allowOrigin(origin)
+	if allowed == "" {
+		return
+	}
+
+	c.Header("Access-Control-Allow-Origin", allowed)
+	if policy.AllowCredentials {
+		c.Header("Access-Control-Allow-Credentials", "true")
+	}
+	c.Header("Access-Control-Expose-Headers", strings.Join(policy.exposeHeaders(config.RequestID), ", "))
+}
+
+// preflight answers the CORS preflight requests of a route path. Without
+// an allowed origin and method, it answers without the CORS headers, and
+// the browser fails the request.
+func (s *Server) preflight(c *gin.Context) {
+	config := s.currentConfig()
+	if !config.CORS.Enabled {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	header := c.Writer.Header()
+	header.Add("Vary", "Origin")
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+
+	path := RoutePath(c)
+	policy := config.CORS.policy(path)
+	methods := policy.AllowedMethods
+	if len(methods) == 0 {
+		methods = s.pathMethods(path)
+	}
+
+	allowed := policy.allowOrigin(c.Request.Header.Get("Origin"))
+	method := strings.ToUpper(c.Request.Header.Get("Access-Control-Request-Method"))
+	if allowed == "" || !containsFold(methods, method) {
+		c.AbortWithStatus(http.StatusNoContent)
+		return
+	}
+
+	c.Header("Access-Control-Allow-Origin", allowed)
+	c.Header("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	c.Header("Access-Control-Allow-Headers", strings.Join(policy.allowHeaders(config.RequestID), ", "))
+	if policy.AllowCredentials {
+		c.Header("Access-Control-Allow-Credentials", "true")
+	}
+	if policy.MaxAge > 0 {
+		c.Header("Access-Control-Max-Age", strconv.Itoa(int(policy.MaxAge.Seconds())))
+	}
+	c.AbortWithStatus(http.StatusNoContent)
+}
+
+// handlePreflight records the methods of the path, and registers its
+// preflight handler once, when the path has no OPTIONS route of its own.
+// The handler checks whether CORS is enabled per request, so it can be
+// turned on by a reload.
+func (s *Server) handlePreflight(route Route) {
+	s.configMutex.Lock()
+	if s.paths == nil {
+		s.paths = map[string][]string{}
+	}
+	methods, registered := s.paths[route.Path]
+	if route.Method != OPTIONS {
+		methods = append(methods, route.Method)
+	}
+	s.paths[route.Path] = methods
+	s.configMutex.Unlock()
+
+	if !registered && route.Method != OPTIONS {
+		s.handle(Route{Method: OPTIONS, Path: route.Path, public: true, untimed: true, unlimited: true}, s.preflight)
+	}
+}
+
+func (s *Server) pathMethods(path string) []string {
+	s.configMutex.RLock()
+	defer s.configMutex.RUnlock()
+	return s.paths[path]
+}
+
+func containsFold(values []string, value string) bool {
+	for _, candidate := range values {
+		if strings.EqualFold(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
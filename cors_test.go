@@ -0,0 +1,230 @@
+package thruster_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tscolari/thruster"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type corsController struct{}
+
+func (corsController) Index(c *gin.Context) (interface{}, error)   { return []string{}, nil }
+func (corsController) Show(c *gin.Context) (interface{}, error)    { return "user", nil }
+func (corsController) Create(c *gin.Context) (interface{}, error)  { return "user", nil }
+func (corsController) Update(c *gin.Context) (interface{}, error)  { return "user", nil }
+func (corsController) Destroy(c *gin.Context) (interface{}, error) { return nil, nil }
+
+var _ = Describe("CORS", func() {
+	var config thruster.Config
+	var subject *thruster.Server
+	var testServer *httptest.Server
+
+	request := func(method, path string, headers map[string]string) *http.Response {
+		request, err := http.NewRequest(method, testServer.URL+path, nil)
+		Expect(err).ToNot(HaveOccurred())
+		for key, value := range headers {
+			request.Header.Set(key, value)
+		}
+		resp, err := http.DefaultClient.Do(request)
+		Expect(err).ToNot(HaveOccurred())
+		return resp
+	}
+
+	preflight := func(path, origin, method string) *http.Response {
+		return request(thruster.OPTIONS, path, map[string]string{
+			"Origin":                        origin,
+			"Access-Control-Request-Method": method,
+		})
+	}
+
+	BeforeEach(func() {
+		config = thruster.Config{
+			CORS: thruster.CORS{
+				Enabled:        true,
+				AllowedOrigins: []string{"https://app.example.com", "https://*.example.org"},
+				ExposedHeaders: []string{"X-Request-ID"},
+				MaxAge:         10 * time.Minute,
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		engine := gin.New()
+		subject = thruster.NewServerWithEngine(config, engine)
+		subject.AddJSONResource("/users", corsController{})
+		subject.AddHandler(thruster.GET, "/public", func(c *gin.Context) {
+			c.String(200, "OK")
+		})
+		subject.AddHandler(thruster.OPTIONS, "/custom", func(c *gin.Context) {
+			c.String(200, "custom")
+		})
+		subject.AddHandler(thruster.GET, "/custom", func(c *gin.Context) {
+			c.String(200, "OK")
+		})
+		testServer = httptest.NewServer(engine)
+	})
+
+	AfterEach(func() {
+		testServer.Close()
+	})
+
+	It("answers the preflight requests of resources", func() {
+		resp := preflight("/users/1", "https://app.example.com", "PUT")
+		Expect(resp.StatusCode).To(Equal(http.StatusNoContent))
+		Expect(resp.Header.Get("Access-Control-Allow-Origin")).To(Equal("https://app.example.com"))
+		Expect(resp.Header.Get("Access-Control-Allow-Methods")).To(Equal("GET, PUT, PATCH, DELETE"))
+		Expect(resp.Header.Get("Access-Control-Allow-Headers")).To(Equal("Accept, Authorization, Content-Type, If-Match, If-None-Match, Idempotency-Key, X-Request-ID"))
+		Expect(resp.Header.Get("Access-Control-Max-Age")).To(Equal("600"))
+	})
+
+	It("doesn't allow the methods of other routes", func() {
+		resp := preflight("/users/1", "https://app.example.com", "POST")
+		Expect(resp.Header.Get("Access-Control-Allow-Origin")).To(BeEmpty())
+	})
+
+	It("allows wildcard subdomains", func() {
+		resp := preflight("/users", "https://admin.example.org", "POST")
+		Expect(resp.Header.Get("Access-Control-Allow-Origin")).To(Equal("https://admin.example.org"))
+
+		resp = preflight("/users", "https://example.org", "POST")
+		Expect(resp.Header.Get("Access-Control-Allow-Origin")).To(BeEmpty())
+
+		resp = preflight("/users", "https://evil.com", "POST")
+		Expect(resp.Header.Get("Access-Control-Allow-Origin")).To(BeEmpty())
+	})
+
+	It("sets the CORS headers of the responses", func() {
+		resp := request(thruster.GET, "/users", map[string]string{"Origin": "https://app.example.com"})
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get("Access-Control-Allow-Origin")).To(Equal("https://app.example.com"))
+		Expect(resp.Header.Get("Access-Control-Expose-Headers")).To(Equal("X-Request-ID"))
+		Expect(resp.Header.Get("Vary")).To(Equal("Origin"))
+
+		resp = request(thruster.GET, "/users", map[string]string{"Origin": "https://evil.com"})
+		Expect(resp.Header.Get("Access-Control-Allow-Origin")).To(BeEmpty())
+	})
+
+	Context("without exposed headers", func() {
+		BeforeEach(func() {
+			config.CORS.ExposedHeaders = nil
+		})
+
+		It("exposes the ETag, rate limit and request ID headers", func() {
+			resp := request(thruster.GET, "/users", map[string]string{"Origin": "https://app.example.com"})
+			Expect(resp.Header.Get("Access-Control-Expose-Headers")).To(Equal(
+				"ETag, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, RateLimit-Policy, Retry-After, X-Request-ID"))
+		})
+	})
+
+	It("varies the responses by origin without one", func() {
+		resp := request(thruster.GET, "/users", nil)
+		Expect(resp.Header.Get("Vary")).To(Equal("Origin"))
+		Expect(resp.Header.Get("Access-Control-Allow-Origin")).To(BeEmpty())
+	})
+
+	It("leaves the preflight requests to the path's own OPTIONS route", func() {
+		resp := preflight("/custom", "https://app.example.com", "GET")
+		body, _ := ioutil.ReadAll(resp.Body)
+		Expect(string(body)).To(Equal("custom"))
+	})
+
+	Context("with credentials", func() {
+		BeforeEach(func() {
+			config.CORS.AllowCredentials = true
+		})
+
+		It("allows them for the allowed origins", func() {
+			resp := request(thruster.GET, "/users", map[string]string{"Origin": "https://app.example.com"})
+			Expect(resp.Header.Get("Access-Control-Allow-Origin")).To(Equal("https://app.example.com"))
+			Expect(resp.Header.Get("Access-Control-Allow-Credentials")).To(Equal("true"))
+		})
+	})
+
+	Context("with a route override", func() {
+		BeforeEach(func() {
+			config.CORS.Routes = map[string]thruster.CORSPolicy{
+				"/public": {AllowedOrigins: []string{"*"}},
+			}
+		})
+
+		It("replaces the policy of that path", func() {
+			resp := request(thruster.GET, "/public", map[string]string{"Origin": "https://any.com"})
+			Expect(resp.Header.Get("Access-Control-Allow-Origin")).To(Equal("*"))
+
+			resp = request(thruster.GET, "/users", map[string]string{"Origin": "https://any.com"})
+			Expect(resp.Header.Get("Access-Control-Allow-Origin")).To(BeEmpty())
+		})
+	})
+
+	Context("with HTTP auth", func() {
+		BeforeEach(func() {
+			config.HTTPAuth = []thruster.HTTPAuth{thruster.NewHTTPAuth("user", "secret")}
+		})
+
+		It("answers the preflight requests without credentials", func() {
+			resp := preflight("/users", "https://app.example.com", "GET")
+			Expect(resp.StatusCode).To(Equal(http.StatusNoContent))
+			Expect(resp.Header.Get("Access-Control-Allow-Origin")).To(Equal("https://app.example.com"))
+		})
+
+		It("sets the CORS headers of the rejected requests", func() {
+			resp := request(thruster.GET, "/users", map[string]string{"Origin": "https://app.example.com"})
+			Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+			Expect(resp.Header.Get("Access-Control-Allow-Origin")).To(Equal("https://app.example.com"))
+		})
+	})
+
+	Context("when disabled", func() {
+		BeforeEach(func() {
+			config.CORS.Enabled = false
+		})
+
+		It("doesn't answer the preflight requests", func() {
+			resp := preflight("/users", "https://app.example.com", "GET")
+			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+			Expect(resp.Header.Get("Access-Control-Allow-Origin")).To(BeEmpty())
+		})
+
+		It("doesn't vary the responses by origin", func() {
+			resp := request(thruster.GET, "/users", nil)
+			Expect(resp.Header.Get("Vary")).To(BeEmpty())
+		})
+
+		It("answers the preflight requests once enabled by a reload", func() {
+			config.Port = 8080
+			config.CORS.Enabled = true
+			Expect(subject.Reload(config)).To(Succeed())
+
+			resp := preflight("/users", "https://app.example.com", "GET")
+			Expect(resp.StatusCode).To(Equal(http.StatusNoContent))
+			Expect(resp.Header.Get("Access-Control-Allow-Origin")).To(Equal("https://app.example.com"))
+		})
+	})
+
+	Describe("validation", func() {
+		It("rejects wildcards other than subdomains", func() {
+			config := thruster.Config{Port: 8080, CORS: thruster.CORS{AllowedOrigins: []string{"https://example.*"}}}
+			Expect(config.Validate()).To(MatchError(ContainSubstring("cors.allowed_origins[0]")))
+		})
+
+		It("rejects the wildcard origin with credentials", func() {
+			config := thruster.Config{Port: 8080, CORS: thruster.CORS{
+				AllowedOrigins: []string{"https://app.example.com", "*"},
+				Routes: map[string]thruster.CORSPolicy{
+					"/public": {AllowedOrigins: []string{"*"}, AllowCredentials: true},
+				},
+				AllowCredentials: true,
+			}}
+			err := config.Validate()
+			Expect(err).To(MatchError(ContainSubstring(`cors.allowed_origins[1]: can't be "*" with allow_credentials`)))
+			Expect(err).To(MatchError(ContainSubstring(`cors.routes[/public].allowed_origins[0]: can't be "*" with allow_credentials`)))
+		})
+	})
+})
@@ -4,4 +4,17 @@ import "errors"
 
 var (
 	ErrNotFound error = errors.New("Not Found")
+	ErrInternal error = errors.New("Internal Server Error")
+	ErrTimeout  error = errors.New("Gateway Timeout")
+
+	ErrBadRequest      error = errors.New("Bad Request")
+	ErrForbidden       error = errors.New("Forbidden")
+	ErrRequestTooLarge error = errors.New("Request Entity Too Large")
+	ErrTooManyRequests error = errors.New("Too Many Requests")
+
+	ErrPreconditionFailed  error = errors.New("Precondition Failed")
+	ErrConflict            error = errors.New("Conflict")
+	ErrUnprocessableEntity error = errors.New("Unprocessable Entity")
+
+	ErrServiceUnavailable error = errors.New("Service Unavailable")
 )
@@ -0,0 +1,161 @@
+package thruster
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const etagKey = "thruster.etag"
+
+// SetETag sets the ETag of a JSON handler's response, instead of the one
+// computed from its body. Unquoted tags are quoted.
+func SetETag(c *gin.Context, etag string) {
+	c.Set(etagKey, quoteETag(etag))
+}
+
+// CheckIfMatch returns ErrPreconditionFailed when the request has an
+// If-Match header that doesn't match etag, the tag of the current
+// representation, for handlers guarding their own updates.
+func CheckIfMatch(c *gin.Context, etag string) error {
+	ifMatch := c.Request.Header.Get("If-Match")
+	if ifMatch == "" || matchETag(ifMatch, quoteETag(etag), false) {
+		return nil
+	}
+	return ErrPreconditionFailed
+}
+
+func quoteETag(etag string) string {
+	if etag == "" || strings.HasPrefix(etag, `"`) || strings.HasPrefix(etag, `W/"`) {
+		return etag
+	}
+	return `"` + etag + `"`
+}
+
+func computeETag(body []byte, weak bool) string {
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	if weak {
+		return "W/" + etag
+	}
+	return etag
+}
+
+// matchETag reports if etag is in the list of an If-Match or If-None-Match
+// header, "*" matching any, as well as the tags of its compressed
+// responses. The weak comparison ignores the W/ prefixes, the strong one
+// never matches weak tags.
+func matchETag(header, etag string, weak bool) bool {
+	if etag == "" {
+		return false
+	}
+
+	tags := []string{etag, encodedETag(etag, "gzip"), encodedETag(etag, "deflate")}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		for _, tag := range tags {
+			switch {
+			case weak && strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(tag, "W/"):
+				return true
+			case !weak && candidate == tag && !strings.HasPrefix(tag, "W/"):
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// encodedETag returns the tag of a response compressed with encoding. The
+// strong tags get the encoding as suffix, as the bytes differ; the weak
+// ones are kept.
+func encodedETag(etag, encoding string) string {
+	if !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `"`) || len(etag) < 2 {
+		return etag
+	}
+	return strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+}
+
+// responseETag returns the tag the handler set, or the one of body, and
+// clears the handler's, so it's used once.
+func responseETag(c *gin.Context, body []byte, weak bool) string {
+	if etag, found := c.Get(etagKey); found && etag.(string) != "" {
+		c.Set(etagKey, "")
+		return etag.(string)
+	}
+	return computeETag(body, weak)
+}
+
+// encodeJSON serializes data as c.JSON does.
+func encodeJSON(data interface{}) ([]byte, error) {
+	var body bytes.Buffer
+	err := json.NewEncoder(&body).Encode(data)
+	return body.Bytes(), err
+}
+
+// respondJSON responds with data, see writeJSON.
+func (s *Server) respondJSON(c *gin.Context, status int, data interface{}) {
+	body, err := encodeJSON(data)
+	if err != nil {
+		c.JSON(status, data)
+		return
+	}
+	s.writeJSON(c, status, body)
+}
+
+// writeJSON responds with the JSON body, tagging GET responses when ETags
+// are enabled, and with 304 Not Modified when the If-None-Match header
+// matches.
+func (s *Server) writeJSON(c *gin.Context, status int, body []byte) {
+	config := s.currentConfig().ETags
+	if !config.Enabled || c.Request.Method != GET {
+		c.Data(status, "application/json; charset=utf-8", body)
+		return
+	}
+
+	etag := responseETag(c, body, config.Weak)
+	c.Header("ETag", etag)
+	if matchETag(c.Request.Header.Get("If-None-Match"), etag, true) {
+		c.Writer.WriteHeader(http.StatusNotModified)
+		return
+	}
+	c.Data(status, "application/json; charset=utf-8", body)
+}
+
+// ifMatch guards handler with the If-Match header, against the tag of the
+// representation returned by current, e.g. the controller's Show. The check
+// is off with weak tags, which If-Match never matches, so clients echoing
+// them don't always get a 412.
+func (s *Server) ifMatch(current, handler JSONHandler) JSONHandler {
+	return func(c *gin.Context) (interface{}, error) {
+		config := s.currentConfig().ETags
+		if !config.Enabled || config.Weak || c.Request.Header.Get("If-Match") == "" {
+			return handler(c)
+		}
+
+		data, err := current(c)
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrPreconditionFailed
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := encodeJSON(data)
+		if err != nil {
+			return nil, err
+		}
+		if err := CheckIfMatch(c, responseETag(c, body, false)); err != nil {
+			return nil, err
+		}
+		return handler(c)
+	}
+}
@@ -0,0 +1,189 @@
+package thruster_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tscolari/thruster"
+	"github.com/tscolari/thruster/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ETags", func() {
+	var config thruster.Config
+	var controller *fakes.FakeJSONController
+	var testServer *httptest.Server
+
+	request := func(method, path string, headers map[string]string) *http.Response {
+		request, err := http.NewRequest(method, testServer.URL+path, nil)
+		Expect(err).ToNot(HaveOccurred())
+		for key, value := range headers {
+			request.Header.Set(key, value)
+		}
+		resp, err := http.DefaultClient.Do(request)
+		Expect(err).ToNot(HaveOccurred())
+		return resp
+	}
+
+	BeforeEach(func() {
+		config = thruster.Config{ETags: thruster.ETags{Enabled: true}}
+		controller = &fakes.FakeJSONController{}
+		controller.ShowReturns(map[string]string{"name": "thruster"}, nil)
+		controller.UpdateReturns(map[string]string{"name": "updated"}, nil)
+	})
+
+	JustBeforeEach(func() {
+		engine := gin.New()
+		subject := thruster.NewServerWithEngine(config, engine)
+		subject.AddJSONResource("/users", controller)
+		subject.AddJSONHandler(thruster.GET, "/tagged", func(c *gin.Context) (interface{}, error) {
+			thruster.SetETag(c, "v1")
+			return "tagged", nil
+		})
+		subject.AddJSONHandler(thruster.GET, "/suffixed", func(c *gin.Context) (interface{}, error) {
+			thruster.SetETag(c, "v1-gzip")
+			return "suffixed", nil
+		})
+		subject.AddJSONHandler(thruster.PATCH, "/tagged", func(c *gin.Context) (interface{}, error) {
+			if err := thruster.CheckIfMatch(c, "v1"); err != nil {
+				return nil, err
+			}
+			return "patched", nil
+		})
+		testServer = httptest.NewServer(engine)
+	})
+
+	AfterEach(func() {
+		testServer.Close()
+	})
+
+	It("tags the GET responses with the hash of their body", func() {
+		resp := request(thruster.GET, "/users/1", nil)
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get("ETag")).To(MatchRegexp(`^"[0-9a-f]{32}"$`))
+
+		body, _ := ioutil.ReadAll(resp.Body)
+		Expect(strings.TrimSpace(string(body))).To(Equal(`{"name":"thruster"}`))
+
+		Expect(request(thruster.GET, "/users/1", nil).Header.Get("ETag")).To(Equal(resp.Header.Get("ETag")))
+	})
+
+	It("answers 304 when If-None-Match matches", func() {
+		etag := request(thruster.GET, "/users/1", nil).Header.Get("ETag")
+
+		resp := request(thruster.GET, "/users/1", map[string]string{"If-None-Match": `"other", ` + etag})
+		Expect(resp.StatusCode).To(Equal(http.StatusNotModified))
+		Expect(resp.Header.Get("ETag")).To(Equal(etag))
+		body, _ := ioutil.ReadAll(resp.Body)
+		Expect(body).To(BeEmpty())
+
+		resp = request(thruster.GET, "/users/1", map[string]string{"If-None-Match": `"other"`})
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+	})
+
+	It("uses the tags set by the handlers", func() {
+		resp := request(thruster.GET, "/tagged", nil)
+		Expect(resp.Header.Get("ETag")).To(Equal(`"v1"`))
+
+		resp = request(thruster.GET, "/tagged", map[string]string{"If-None-Match": `"v1"`})
+		Expect(resp.StatusCode).To(Equal(http.StatusNotModified))
+	})
+
+	It("doesn't take the handler tags for the ones of compressed responses", func() {
+		resp := request(thruster.GET, "/suffixed", map[string]string{"If-None-Match": `"v1-gzip"`})
+		Expect(resp.StatusCode).To(Equal(http.StatusNotModified))
+
+		resp = request(thruster.GET, "/tagged", map[string]string{"If-None-Match": `"v1-gzip"`})
+		Expect(resp.StatusCode).To(Equal(http.StatusNotModified))
+
+		resp = request(thruster.GET, "/suffixed", map[string]string{"If-None-Match": `"v1"`})
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+	})
+
+	It("answers 412 to updates whose If-Match doesn't match the current tag", func() {
+		resp := request(thruster.PUT, "/users/1", map[string]string{"If-Match": `"stale"`})
+		Expect(resp.StatusCode).To(Equal(http.StatusPreconditionFailed))
+		Expect(controller.UpdateCallCount()).To(Equal(0))
+
+		resp = request(thruster.PATCH, "/users/1", map[string]string{"If-Match": `"stale"`})
+		Expect(resp.StatusCode).To(Equal(http.StatusPreconditionFailed))
+		Expect(controller.UpdateCallCount()).To(Equal(0))
+
+		resp = request(thruster.DELETE, "/users/1", map[string]string{"If-Match": `"stale"`})
+		Expect(resp.StatusCode).To(Equal(http.StatusPreconditionFailed))
+		Expect(controller.DestroyCallCount()).To(Equal(0))
+	})
+
+	It("runs the updates whose If-Match matches the current tag", func() {
+		etag := request(thruster.GET, "/users/1", nil).Header.Get("ETag")
+
+		resp := request(thruster.PUT, "/users/1", map[string]string{"If-Match": etag})
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(controller.UpdateCallCount()).To(Equal(1))
+
+		resp = request(thruster.PATCH, "/users/1", map[string]string{"If-Match": etag})
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(controller.UpdateCallCount()).To(Equal(2))
+
+		resp = request(thruster.PUT, "/users/1", map[string]string{"If-Match": "*"})
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(controller.UpdateCallCount()).To(Equal(3))
+	})
+
+	It("answers 412 to If-Match on missing resources", func() {
+		controller.ShowReturns(nil, thruster.ErrNotFound)
+
+		resp := request(thruster.PUT, "/users/1", map[string]string{"If-Match": "*"})
+		Expect(resp.StatusCode).To(Equal(http.StatusPreconditionFailed))
+	})
+
+	It("lets handlers check If-Match themselves", func() {
+		Expect(request(thruster.PATCH, "/tagged", map[string]string{"If-Match": `"v1"`}).StatusCode).To(Equal(http.StatusOK))
+		Expect(request(thruster.PATCH, "/tagged", map[string]string{"If-Match": `"v2"`}).StatusCode).To(Equal(http.StatusPreconditionFailed))
+		Expect(request(thruster.PATCH, "/tagged", nil).StatusCode).To(Equal(http.StatusOK))
+	})
+
+	Context("with weak tags", func() {
+		BeforeEach(func() {
+			config.ETags.Weak = true
+		})
+
+		It("tags weakly and still answers 304", func() {
+			etag := request(thruster.GET, "/users/1", nil).Header.Get("ETag")
+			Expect(etag).To(HavePrefix(`W/"`))
+
+			resp := request(thruster.GET, "/users/1", map[string]string{"If-None-Match": strings.TrimPrefix(etag, "W/")})
+			Expect(resp.StatusCode).To(Equal(http.StatusNotModified))
+		})
+
+		It("doesn't check If-Match", func() {
+			etag := request(thruster.GET, "/users/1", nil).Header.Get("ETag")
+
+			resp := request(thruster.PUT, "/users/1", map[string]string{"If-Match": etag})
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(controller.UpdateCallCount()).To(Equal(1))
+
+			resp = request(thruster.PUT, "/users/1", map[string]string{"If-Match": `"stale"`})
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(controller.UpdateCallCount()).To(Equal(2))
+		})
+	})
+
+	Context("when disabled", func() {
+		BeforeEach(func() {
+			config.ETags.Enabled = false
+		})
+
+		It("doesn't tag nor check preconditions", func() {
+			Expect(request(thruster.GET, "/users/1", nil).Header.Get("ETag")).To(BeEmpty())
+
+			resp := request(thruster.PUT, "/users/1", map[string]string{"If-Match": `"stale"`})
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		})
+	})
+})
@@ -0,0 +1,119 @@
+package thruster
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultExplorerPath = "/explorer"
+
+var explorerTemplate = template.Must(template.New("explorer").Parse(explorerPage))
+
+type explorerRoute struct {
+	Method  string
+	Path    string
+	Params  []string
+	HasBody bool
+	Example string
+}
+
+func (e Explorer) enabled() bool {
+	if !e.Enabled {
+		return false
+	}
+
+	return gin.Mode() != gin.ReleaseMode || e.AllowInRelease
+}
+
+func (e Explorer) path() string {
+	if e.Path == "" {
+		return defaultExplorerPath
+	}
+	return e.Path
+}
+
+func (s *Server) mountExplorer() {
+	explorer := s.currentConfig().Explorer
+	if !explorer.enabled() {
+		return
+	}
+
+	path := explorer.path()
+	s.handle(Route{Method: GET, Path: path, contentSecurityPolicy: explorerContentSecurityPolicy}, s.explorerHandler)
+	s.handle(Route{Method: GET, Path: strings.TrimRight(path, "/") + "/routes.json"}, func(c *gin.Context) {
+		c.JSON(http.StatusOK, s.explorerRoutes(c.Request))
+	})
+}
+
+func (s *Server) explorerHandler(c *gin.Context) {
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Writer.WriteHeader(http.StatusOK)
+
+	data := map[string]interface{}{"Routes": s.explorerRoutes(c.Request)}
+	if err := explorerTemplate.Execute(c.Writer, data); err != nil {
+		c.Error(err)
+	}
+}
+
+func (s *Server) explorerRoutes(request *http.Request) []explorerRoute {
+	scheme := "http"
+	if request.TLS != nil {
+		scheme = "https"
+	}
+	baseURL := scheme + "://" + request.Host
+
+	routes := []explorerRoute{}
+	for _, route := range s.Routes() {
+		if !route.JSON {
+			continue
+		}
+
+		routes = append(routes, explorerRoute{
+			Method:  route.Method,
+			Path:    route.Path,
+			Params:  routeParams(route.Path),
+			HasBody: hasBody(route.Method),
+			Example: s.curlExample(baseURL, route),
+		})
+	}
+
+	return routes
+}
+
+func (s *Server) curlExample(baseURL string, route Route) string {
+	parts := []string{"curl", "-X", route.Method}
+
+	if len(s.currentConfig().HTTPAuth) > 0 {
+		parts = append(parts, "-u", "'<username>:<password>'")
+	}
+
+	if hasBody(route.Method) {
+		parts = append(parts, "-H", "'Content-Type: application/json'", "-d", "'{}'")
+	}
+
+	path := route.Path
+	for _, param := range routeParams(route.Path) {
+		path = strings.Replace(path, ":"+param, "<"+param+">", 1)
+		path = strings.Replace(path, "*"+param, "<"+param+">", 1)
+	}
+
+	return strings.Join(append(parts, "'"+baseURL+path+"'"), " ")
+}
+
+// hasBody reports if the requests of method take a JSON body.
+func hasBody(method string) bool {
+	return method == POST || method == PUT || method == PATCH
+}
+
+func routeParams(path string) []string {
+	params := []string{}
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			params = append(params, segment[1:])
+		}
+	}
+	return params
+}
@@ -0,0 +1,68 @@
+package thruster
+
+// explorerPage is the single page served by the API explorer. It is kept
+// inline, together with its stylesheet and script, so the explorer works
+// without any network access.
+const explorerPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>API Explorer</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.4em; }
+.route { border: 1px solid #ddd; border-radius: 4px; margin-bottom: 1em; padding: 0.8em; }
+.method { display: inline-block; min-width: 5em; font-weight: bold; }
+.GET { color: #2a7ae2; } .POST { color: #2b9b48; } .PUT, .PATCH { color: #c98a10; } .DELETE { color: #c0392b; }
+pre { background: #f6f6f6; padding: 0.6em; overflow-x: auto; }
+label { display: block; margin: 0.3em 0; }
+textarea { width: 100%; height: 6em; font-family: monospace; }
+.response { display: none; }
+</style>
+</head>
+<body>
+<h1>API Explorer</h1>
+{{if not .Routes}}<p>No JSON routes registered.</p>{{end}}
+{{range $i, $route := .Routes}}
+<div class="route">
+  <div><span class="method {{$route.Method}}">{{$route.Method}}</span> <code>{{$route.Path}}</code></div>
+  <pre>{{$route.Example}}</pre>
+  <form data-method="{{$route.Method}}" data-path="{{$route.Path}}" onsubmit="return tryIt(this)">
+    {{range $route.Params}}<label>{{.}} <input name="{{.}}" data-param="true"></label>{{end}}
+    {{if $route.HasBody}}<label>Body <textarea name="body">{}</textarea></label>{{end}}
+    <button type="submit">Try it</button>
+  </form>
+  <pre class="response" id="response-{{$i}}"></pre>
+</div>
+{{end}}
+<script>
+function tryIt(form) {
+  var path = form.getAttribute("data-path");
+  var method = form.getAttribute("data-method");
+  var inputs = form.querySelectorAll("input[data-param]");
+  for (var i = 0; i < inputs.length; i++) {
+    var value = encodeURIComponent(inputs[i].value);
+    path = path.replace(":" + inputs[i].name, value).replace("*" + inputs[i].name, value);
+  }
+  var options = { method: method, credentials: "same-origin", headers: {} };
+  if (form.body) {
+    options.body = form.body.value;
+    options.headers["Content-Type"] = "application/json";
+  }
+  var output = form.nextElementSibling;
+  output.style.display = "block";
+  output.textContent = method + " " + path + " ...";
+  fetch(path, options).then(function (resp) {
+    return resp.text().then(function (text) {
+      try { text = JSON.stringify(JSON.parse(text), null, 2); } catch (e) {}
+      output.textContent = resp.status + " " + resp.statusText + "\n\n" + text;
+    });
+  }).catch(function (err) {
+    output.textContent = "Request failed: " + err;
+  });
+  return false;
+}
+</script>
+</body>
+</html>
+`
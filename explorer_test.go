@@ -0,0 +1,147 @@
+package thruster_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tscolari/thruster"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("API Explorer", func() {
+	var subject *thruster.Server
+	var config thruster.Config
+	var testServer *httptest.Server
+
+	jsonHandler := func(c *gin.Context) (interface{}, error) {
+		return map[string]string{"key": "value"}, nil
+	}
+
+	BeforeEach(func() {
+		config = thruster.Config{
+			Explorer: thruster.Explorer{Enabled: true},
+		}
+	})
+
+	JustBeforeEach(func() {
+		engine := gin.New()
+		subject = thruster.NewServerWithEngine(config, engine)
+		subject.AddJSONHandler(thruster.GET, "/users/:id", jsonHandler)
+		subject.AddJSONHandler(thruster.POST, "/users", jsonHandler)
+		subject.AddJSONHandler(thruster.PATCH, "/users/:id", jsonHandler)
+		subject.AddHandler(thruster.GET, "/plain", func(c *gin.Context) {
+			c.String(200, "OK")
+		})
+		testServer = httptest.NewServer(engine)
+	})
+
+	AfterEach(func() {
+		testServer.Close()
+	})
+
+	It("serves the explorer page listing the JSON routes", func() {
+		resp := makeSimpleRequest(thruster.GET, testServer.URL+"/explorer")
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get("Content-Type")).To(ContainSubstring("text/html"))
+
+		body, err := ioutil.ReadAll(resp.Body)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(body)).To(ContainSubstring("/users/:id"))
+		Expect(string(body)).To(ContainSubstring("Try it"))
+		Expect(string(body)).ToNot(ContainSubstring("/plain"))
+	})
+
+	It("serves the routes with example requests as JSON", func() {
+		resp := makeSimpleRequest(thruster.GET, testServer.URL+"/explorer/routes.json")
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+		var routes []map[string]interface{}
+		Expect(json.NewDecoder(resp.Body).Decode(&routes)).To(Succeed())
+		Expect(routes).To(HaveLen(3))
+		Expect(routes[0]["Path"]).To(Equal("/users/:id"))
+		Expect(routes[0]["Example"]).To(Equal("curl -X GET '" + testServer.URL + "/users/<id>'"))
+		Expect(routes[1]["Example"]).To(ContainSubstring("-d '{}'"))
+		Expect(routes[2]["HasBody"]).To(BeTrue())
+		Expect(routes[2]["Example"]).To(ContainSubstring("-d '{}'"))
+	})
+
+	It("records every registered route", func() {
+		Expect(subject.Routes()).To(Equal([]thruster.Route{
+			{Method: thruster.GET, Path: "/users/:id", JSON: true},
+			{Method: thruster.POST, Path: "/users", JSON: true},
+			{Method: thruster.PATCH, Path: "/users/:id", JSON: true},
+			{Method: thruster.GET, Path: "/plain", JSON: false},
+		}))
+	})
+
+	Context("when a custom path is configured", func() {
+		BeforeEach(func() {
+			config.Explorer.Path = "/_docs"
+		})
+
+		It("serves the explorer on that path", func() {
+			resp := makeSimpleRequest(thruster.GET, testServer.URL+"/_docs")
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		})
+	})
+
+	Context("when HTTP auth is configured", func() {
+		BeforeEach(func() {
+			config.HTTPAuth = []thruster.HTTPAuth{thruster.NewHTTPAuth("admin", "passwd")}
+		})
+
+		It("requires the credentials", func() {
+			resp := makeSimpleRequest(thruster.GET, testServer.URL+"/explorer")
+			Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+
+			request, err := http.NewRequest(thruster.GET, testServer.URL+"/explorer", nil)
+			Expect(err).ToNot(HaveOccurred())
+			request.SetBasicAuth("admin", "passwd")
+			resp, err = http.DefaultClient.Do(request)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		})
+	})
+
+	Context("when it is not enabled", func() {
+		BeforeEach(func() {
+			config.Explorer.Enabled = false
+		})
+
+		It("is not served", func() {
+			resp := makeSimpleRequest(thruster.GET, testServer.URL+"/explorer")
+			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Context("in release mode", func() {
+		BeforeEach(func() {
+			gin.SetMode(gin.ReleaseMode)
+		})
+
+		AfterEach(func() {
+			gin.SetMode(gin.TestMode)
+		})
+
+		It("is disabled by default", func() {
+			resp := makeSimpleRequest(thruster.GET, testServer.URL+"/explorer")
+			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+		})
+
+		Context("when explicitly allowed", func() {
+			BeforeEach(func() {
+				config.Explorer.AllowInRelease = true
+			})
+
+			It("is served", func() {
+				resp := makeSimpleRequest(thruster.GET, testServer.URL+"/explorer")
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			})
+		})
+	})
+})
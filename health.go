@@ -0,0 +1,204 @@
+package thruster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultLivenessPath       = "/livez"
+	defaultReadinessPath      = "/readyz"
+	defaultHealthCheckTimeout = 5 * time.Second
+
+	HealthStatusOK      = "ok"
+	HealthStatusFailing = "failing"
+)
+
+var ErrShuttingDown = errors.New("shutting down")
+
+// HealthCheck reports the health of a dependency of the server. The context
+// is cancelled once the check Timeout is over.
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+	// Timeout defaults to Health.CheckTimeout. A CacheTTL reuses the last
+	// result for that long, for checks too expensive to run on every probe.
+	Timeout  time.Duration
+	CacheTTL time.Duration
+
+	mutex     sync.Mutex
+	checkedAt time.Time
+	result    HealthCheckResult
+}
+
+type HealthCheckResult struct {
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+type HealthReport struct {
+	Status string                       `json:"status"`
+	Checks map[string]HealthCheckResult `json:"checks"`
+}
+
+// AddLivenessCheck registers a check for the liveness endpoint. A failing
+// liveness usually gets the process restarted, so keep these for
+// unrecoverable conditions. It panics if the name is taken.
+func (s *Server) AddLivenessCheck(check *HealthCheck) {
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+	s.livenessChecks = addHealthCheck(s.livenessChecks, check)
+}
+
+// AddReadinessCheck registers a check for the readiness endpoint, failing
+// while the server can't take traffic, e.g. its database is unreachable.
+// It panics if the name is taken, including by the "shutdown" check.
+func (s *Server) AddReadinessCheck(check *HealthCheck) {
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+	if check.Name == "shutdown" {
+		panic(`thruster: the readiness check name "shutdown" is reserved`)
+	}
+	s.readinessChecks = addHealthCheck(s.readinessChecks, check)
+}
+
+// addHealthCheck appends check, whose name must be unique, as the reports
+// are keyed by it.
+func addHealthCheck(checks []*HealthCheck, check *HealthCheck) []*HealthCheck {
+	for _, existing := range checks {
+		if existing.Name == check.Name {
+			panic(fmt.Sprintf("thruster: a health check named %q is already registered", check.Name))
+		}
+	}
+	return append(checks, check)
+}
+
+func (h Health) livenessPath() string {
+	if h.LivenessPath == "" {
+		return defaultLivenessPath
+	}
+	return h.LivenessPath
+}
+
+func (h Health) readinessPath() string {
+	if h.ReadinessPath == "" {
+		return defaultReadinessPath
+	}
+	return h.ReadinessPath
+}
+
+func (h Health) checkTimeout() time.Duration {
+	if h.CheckTimeout <= 0 {
+		return defaultHealthCheckTimeout
+	}
+	return h.CheckTimeout
+}
+
+func (s *Server) mountHealth() {
+	health := s.currentConfig().Health
+	if !health.Enabled {
+		return
+	}
+
+	public := !health.RequireAuth
+	s.handle(Route{Method: GET, Path: health.livenessPath(), public: public, unlimited: true, unthrottled: true}, func(c *gin.Context) {
+		s.configMutex.RLock()
+		checks := s.livenessChecks
+		s.configMutex.RUnlock()
+
+		s.writeHealthReport(c, s.runHealthChecks(c, checks))
+	})
+
+	s.handle(Route{Method: GET, Path: health.readinessPath(), public: public, unlimited: true, unthrottled: true}, func(c *gin.Context) {
+		s.configMutex.RLock()
+		checks := s.readinessChecks
+		s.configMutex.RUnlock()
+
+		report := s.runHealthChecks(c, checks)
+		if s.ShuttingDown() {
+			report.Status = HealthStatusFailing
+			report.Checks["shutdown"] = HealthCheckResult{Status: HealthStatusFailing, Error: ErrShuttingDown.Error()}
+		}
+		s.writeHealthReport(c, report)
+	})
+}
+
+func (s *Server) writeHealthReport(c *gin.Context, report HealthReport) {
+	status := http.StatusOK
+	if report.Status != HealthStatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(status, report)
+}
+
+// runHealthChecks runs the checks concurrently.
+func (s *Server) runHealthChecks(c *gin.Context, checks []*HealthCheck) HealthReport {
+	timeout := s.currentConfig().Health.checkTimeout()
+	report := HealthReport{Status: HealthStatusOK, Checks: map[string]HealthCheckResult{}}
+
+	results := make([]HealthCheckResult, len(checks))
+	wait := sync.WaitGroup{}
+	for i, check := range checks {
+		wait.Add(1)
+		go func(i int, check *HealthCheck) {
+			defer wait.Done()
+			results[i] = check.run(c.Request.Context(), timeout)
+		}(i, check)
+	}
+	wait.Wait()
+
+	for i, check := range checks {
+		report.Checks[check.Name] = results[i]
+		if results[i].Status != HealthStatusOK {
+			report.Status = HealthStatusFailing
+		}
+	}
+	return report
+}
+
+func (h *HealthCheck) run(ctx context.Context, defaultTimeout time.Duration) HealthCheckResult {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.CacheTTL > 0 && !h.checkedAt.IsZero() && time.Since(h.checkedAt) < h.CacheTTL {
+		return h.result
+	}
+
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	errs := make(chan error, 1)
+	go func() { errs <- h.Check(ctx) }()
+
+	var err error
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	h.result = HealthCheckResult{
+		Status:     HealthStatusOK,
+		DurationMS: float64(time.Since(start)) / float64(time.Millisecond),
+	}
+	if err != nil {
+		h.result.Status = HealthStatusFailing
+		h.result.Error = err.Error()
+	}
+	h.checkedAt = time.Now()
+	return h.result
+}
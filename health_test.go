@@ -0,0 +1,238 @@
+package thruster_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tscolari/thruster"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Health", func() {
+	var subject *thruster.Server
+	var config thruster.Config
+	var testServer *httptest.Server
+
+	probe := func(path string) (*http.Response, thruster.HealthReport) {
+		resp := makeSimpleRequest(thruster.GET, testServer.URL+path)
+		defer resp.Body.Close()
+
+		var report thruster.HealthReport
+		Expect(json.NewDecoder(resp.Body).Decode(&report)).To(Succeed())
+		return resp, report
+	}
+
+	BeforeEach(func() {
+		config = thruster.Config{
+			Health: thruster.Health{Enabled: true},
+		}
+	})
+
+	JustBeforeEach(func() {
+		engine := gin.New()
+		subject = thruster.NewServerWithEngine(config, engine)
+		subject.AddHandler(thruster.GET, "/test", func(c *gin.Context) {
+			c.String(200, "OK")
+		})
+		testServer = httptest.NewServer(engine)
+	})
+
+	AfterEach(func() {
+		testServer.Close()
+	})
+
+	It("reports ok without checks", func() {
+		resp, report := probe("/livez")
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(report.Status).To(Equal(thruster.HealthStatusOK))
+
+		resp, report = probe("/readyz")
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(report.Status).To(Equal(thruster.HealthStatusOK))
+	})
+
+	It("reports each check", func() {
+		subject.AddReadinessCheck(&thruster.HealthCheck{
+			Name:  "database",
+			Check: func(context.Context) error { return nil },
+		})
+		subject.AddReadinessCheck(&thruster.HealthCheck{
+			Name:  "cache",
+			Check: func(context.Context) error { return errors.New("connection refused") },
+		})
+
+		resp, report := probe("/readyz")
+		Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+		Expect(report.Status).To(Equal(thruster.HealthStatusFailing))
+		Expect(report.Checks["database"].Status).To(Equal(thruster.HealthStatusOK))
+		Expect(report.Checks["cache"].Status).To(Equal(thruster.HealthStatusFailing))
+		Expect(report.Checks["cache"].Error).To(Equal("connection refused"))
+
+		resp, _ = probe("/livez")
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+	})
+
+	It("fails checks that time out", func() {
+		subject.AddLivenessCheck(&thruster.HealthCheck{
+			Name:    "slow",
+			Timeout: 10 * time.Millisecond,
+			Check: func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		})
+
+		resp, report := probe("/livez")
+		Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+		Expect(report.Checks["slow"].Error).To(Equal(context.DeadlineExceeded.Error()))
+	})
+
+	It("caches results for the check CacheTTL", func() {
+		var calls int32
+		subject.AddReadinessCheck(&thruster.HealthCheck{
+			Name:     "expensive",
+			CacheTTL: time.Minute,
+			Check: func(context.Context) error {
+				atomic.AddInt32(&calls, 1)
+				return nil
+			},
+		})
+
+		probe("/readyz")
+		probe("/readyz")
+		Expect(atomic.LoadInt32(&calls)).To(Equal(int32(1)))
+	})
+
+	It("fails readiness once shutting down", func() {
+		Expect(subject.Shutdown(context.Background())).To(Succeed())
+
+		resp, report := probe("/readyz")
+		Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+		Expect(report.Checks["shutdown"].Error).To(Equal("shutting down"))
+
+		resp, _ = probe("/livez")
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+	})
+
+	Context("with HTTP auth", func() {
+		BeforeEach(func() {
+			config.HTTPAuth = []thruster.HTTPAuth{thruster.NewHTTPAuth("user", "secret")}
+		})
+
+		It("bypasses the auth by default", func() {
+			resp, _ := probe("/readyz")
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		})
+
+		Context("when require_auth is set", func() {
+			BeforeEach(func() {
+				config.Health.RequireAuth = true
+			})
+
+			It("requires the auth", func() {
+				resp := makeSimpleRequest(thruster.GET, testServer.URL+"/readyz")
+				Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+			})
+		})
+	})
+
+	Context("with custom paths", func() {
+		BeforeEach(func() {
+			config.Health.LivenessPath = "/health/live"
+			config.Health.ReadinessPath = "/health/ready"
+		})
+
+		It("serves them", func() {
+			resp, _ := probe("/health/live")
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			resp, _ = probe("/health/ready")
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		})
+	})
+
+	Context("when disabled", func() {
+		BeforeEach(func() {
+			config.Health.Enabled = false
+		})
+
+		It("doesn't serve the endpoints", func() {
+			resp := makeSimpleRequest(thruster.GET, testServer.URL+"/livez")
+			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	It("rejects checks with a taken name", func() {
+		check := func(ctx context.Context) error { return nil }
+		subject.AddReadinessCheck(&thruster.HealthCheck{Name: "db", Check: check})
+		subject.AddLivenessCheck(&thruster.HealthCheck{Name: "db", Check: check})
+
+		Expect(func() {
+			subject.AddReadinessCheck(&thruster.HealthCheck{Name: "db", Check: check})
+		}).To(Panic())
+		Expect(func() {
+			subject.AddReadinessCheck(&thruster.HealthCheck{Name: "shutdown", Check: check})
+		}).To(Panic())
+	})
+
+	Describe("Shutdown", func() {
+		It("stops Run", func() {
+			config = thruster.Config{Hostname: "localhost", Port: 9321}
+			server := thruster.NewServer(config)
+
+			done := make(chan error, 1)
+			go func() { done <- server.Run() }()
+			Eventually(func() error {
+				_, err := http.Get("http://localhost:9321/")
+				return err
+			}).Should(Succeed())
+
+			Expect(server.Shutdown(context.Background())).To(Succeed())
+			Eventually(done).Should(Receive(BeNil()))
+		})
+
+		It("returns from Run once the requests in progress are done", func() {
+			config = thruster.Config{Hostname: "localhost", Port: 9322}
+			server := thruster.NewServer(config)
+			started := make(chan bool, 1)
+			finished := int32(0)
+			server.AddHandler(thruster.GET, "/slow", func(c *gin.Context) {
+				started <- true
+				time.Sleep(100 * time.Millisecond)
+				atomic.StoreInt32(&finished, 1)
+				c.String(200, "OK")
+			})
+
+			done := make(chan error, 1)
+			go func() { done <- server.Run() }()
+			Eventually(func() error {
+				_, err := http.Get("http://localhost:9322/")
+				return err
+			}).Should(Succeed())
+
+			go http.Get("http://localhost:9322/slow")
+			Eventually(started).Should(Receive())
+			go server.Shutdown(context.Background())
+
+			Eventually(done).Should(Receive(BeNil()))
+			Expect(atomic.LoadInt32(&finished)).To(Equal(int32(1)))
+		})
+
+		It("stops a Run that hasn't started serving", func() {
+			config = thruster.Config{Hostname: "localhost", Port: 9323}
+			server := thruster.NewServer(config)
+
+			done := make(chan error, 1)
+			go func() { done <- server.Run() }()
+			Expect(server.Shutdown(context.Background())).To(Succeed())
+			Eventually(done).Should(Receive(BeNil()))
+		})
+	})
+})
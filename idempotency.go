@@ -0,0 +1,299 @@
+package thruster
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	defaultIdempotencyTTL   = 24 * time.Hour
+	maxIdempotencyKeyLength = 255
+)
+
+// IdempotencyStore keeps the responses of the requests with an
+// Idempotency-Key. The default one is in memory; a shared one replays them
+// across instances.
+type IdempotencyStore interface {
+	// Reserve stores the in-flight record at key for ttl, unless there is
+	// one already, which it returns instead.
+	Reserve(key string, record *IdempotencyRecord, ttl time.Duration) (*IdempotencyRecord, error)
+	// Complete replaces the record at key with the completed one.
+	Complete(key string, record *IdempotencyRecord, ttl time.Duration) error
+	// Release drops the record at key, so the request can be retried.
+	Release(key string) error
+}
+
+// IdempotencyRecord is a request in flight, identified by the Fingerprint
+// of its payload, and once Completed, its response.
+type IdempotencyRecord struct {
+	Fingerprint string
+	Completed   bool
+	Status      int
+	Header      http.Header
+	Body        []byte
+}
+
+// SetIdempotencyStore keeps the idempotent responses in store, instead of
+// in memory.
+func (s *Server) SetIdempotencyStore(store IdempotencyStore) {
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+	s.idempotencyStore = store
+}
+
+func (s *Server) idempotencyRecords() IdempotencyStore {
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+	if s.idempotencyStore == nil {
+		s.idempotencyStore = NewMemoryIdempotencyStore()
+	}
+	return s.idempotencyStore
+}
+
+func (i Idempotency) ttl() time.Duration {
+	if i.TTL <= 0 {
+		return defaultIdempotencyTTL
+	}
+	return i.TTL
+}
+
+func (i Idempotency) applies(route Route) bool {
+	if !i.Enabled {
+		return false
+	}
+	for _, key := range i.Routes {
+		if key == route.Method+" "+route.Path {
+			return true
+		}
+	}
+	return false
+}
+
+// idempotent replays the stored response to the retries of a request with
+// the same Idempotency-Key, from the same user, or client IP without HTTP
+// auth. Reusing the key with another payload is rejected with a 422, and
+// retrying while the first request is in flight with a 409. The handler's
+// response is stored once it returns, even past the timeout, except for
+// panics and server errors, so those requests can be retried.
+func (s *Server) idempotent(c *gin.Context) {
+	config := s.currentConfig().Idempotency
+	route, _ := CurrentRoute(c)
+	idempotencyKey := c.Request.Header.Get(IdempotencyKeyHeader)
+	if idempotencyKey == "" || !config.applies(route) {
+		return
+	}
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		abortWithError(c, http.StatusBadRequest, ErrBadRequest)
+		return
+	}
+
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		if errors.Is(err, ErrRequestTooLarge) {
+			abortWithError(c, http.StatusRequestEntityTooLarge, ErrRequestTooLarge)
+		} else {
+			abortWithError(c, http.StatusBadRequest, ErrBadRequest)
+		}
+		return
+	}
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	key := route.Method + " " + route.Path + "|" + idempotencyPrincipal(c) + "|" + idempotencyKey
+	fingerprint := idempotencyFingerprint(c.Request, body)
+	store := s.idempotencyRecords()
+
+	record, err := store.Reserve(key, &IdempotencyRecord{Fingerprint: fingerprint}, config.ttl())
+	if err != nil {
+		// Lets the request through rather than failing on a store outage.
+		s.logf(LogLevelWarn, "idempotency: %s", err)
+		return
+	}
+
+	switch {
+	case record == nil:
+	case record.Fingerprint != fingerprint:
+		abortWithError(c, http.StatusUnprocessableEntity, ErrUnprocessableEntity)
+		return
+	case !record.Completed:
+		c.Header("Retry-After", "1")
+		abortWithError(c, http.StatusConflict, ErrConflict)
+		return
+	default:
+		replay(c, record)
+		return
+	}
+
+	header := c.Writer.Header()
+	before := make(http.Header, len(header))
+	for name, values := range header {
+		before[name] = values
+	}
+
+	writer := &recordingWriter{ResponseWriter: c.Writer}
+	c.Writer = writer
+	completed := false
+	defer func() {
+		c.Writer = writer.ResponseWriter
+		status := handlerStatus(writer.ResponseWriter)
+		if !completed || status >= http.StatusInternalServerError {
+			if err := store.Release(key); err != nil {
+				s.logf(LogLevelWarn, "idempotency: %s", err)
+			}
+			return
+		}
+
+		record := &IdempotencyRecord{
+			Fingerprint: fingerprint,
+			Completed:   true,
+			Status:      status,
+			Header:      handlerHeader(before, header),
+			Body:        writer.body.Bytes(),
+		}
+		if err := store.Complete(key, record, config.ttl()); err != nil {
+			s.logf(LogLevelWarn, "idempotency: %s", err)
+		}
+	}()
+
+	c.Next()
+	completed = true
+}
+
+// handlerStatus is the status the handler responded with, rather than the
+// 504 of a timeout.
+func handlerStatus(writer gin.ResponseWriter) int {
+	if writer, ok := writer.(*timeoutWriter); ok {
+		return writer.renderer.Status()
+	}
+	return writer.Status()
+}
+
+func idempotencyPrincipal(c *gin.Context) string {
+	if user := AuthenticatedUser(c); user != "" {
+		return "user:" + user
+	}
+	return "ip:" + ClientIP(c)
+}
+
+func idempotencyFingerprint(request *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(request.Method + " " + request.URL.RequestURI() + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// handlerHeader returns the headers set or changed since before. The
+// encoding ones are left out, as the replays are encoded again.
+func handlerHeader(before, after http.Header) http.Header {
+	header := http.Header{}
+	for name, values := range after {
+		if name == "Content-Encoding" || name == "Content-Length" {
+			continue
+		}
+		if previous, found := before[name]; found && strings.Join(previous, "\n") == strings.Join(values, "\n") {
+			continue
+		}
+		header[name] = append([]string(nil), values...)
+	}
+	return header
+}
+
+func replay(c *gin.Context, record *IdempotencyRecord) {
+	for name, values := range record.Header {
+		c.Writer.Header()[name] = values
+	}
+	c.Header(IdempotentReplayedHeader, "true")
+	c.Writer.WriteHeader(record.Status)
+	c.Writer.Write(record.Body)
+	c.Abort()
+}
+
+// recordingWriter keeps a copy of the body the handler wrote, including
+// what was dropped after a timeout.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(data string) (int, error) {
+	return w.Write([]byte(data))
+}
+
+// MemoryIdempotencyStore keeps the records in memory, dropping the expired
+// ones.
+type MemoryIdempotencyStore struct {
+	mutex   sync.Mutex
+	records map[string]*memoryIdempotencyRecord
+	now     func() time.Time
+	swept   time.Time
+}
+
+type memoryIdempotencyRecord struct {
+	record  *IdempotencyRecord
+	expires time.Time
+}
+
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		records: map[string]*memoryIdempotencyRecord{},
+		now:     time.Now,
+	}
+}
+
+func (m *MemoryIdempotencyStore) Reserve(key string, record *IdempotencyRecord, ttl time.Duration) (*IdempotencyRecord, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+
+	if existing, found := m.records[key]; found && now.Before(existing.expires) {
+		return existing.record, nil
+	}
+	m.records[key] = &memoryIdempotencyRecord{record: record, expires: now.Add(ttl)}
+	return nil, nil
+}
+
+func (m *MemoryIdempotencyStore) Complete(key string, record *IdempotencyRecord, ttl time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.records[key] = &memoryIdempotencyRecord{record: record, expires: m.now().Add(ttl)}
+	return nil
+}
+
+func (m *MemoryIdempotencyStore) Release(key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.records, key)
+	return nil
+}
+
+// sweep drops the expired records, at most once a minute.
+func (m *MemoryIdempotencyStore) sweep(now time.Time) {
+	if now.Sub(m.swept) < time.Minute {
+		return
+	}
+	m.swept = now
+
+	for key, record := range m.records {
+		if !now.Before(record.expires) {
+			delete(m.records, key)
+		}
+	}
+}
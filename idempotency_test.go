@@ -0,0 +1,209 @@
+package thruster_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tscolari/thruster"
+	"github.com/tscolari/thruster/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Idempotency", func() {
+	var config thruster.Config
+	var controller *fakes.FakeJSONController
+	var created int32
+	var testServer *httptest.Server
+
+	post := func(key, payload string) (*http.Response, string) {
+		request, err := http.NewRequest(thruster.POST, testServer.URL+"/users", strings.NewReader(payload))
+		Expect(err).ToNot(HaveOccurred())
+		if key != "" {
+			request.Header.Set("Idempotency-Key", key)
+		}
+		resp, err := http.DefaultClient.Do(request)
+		Expect(err).ToNot(HaveOccurred())
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp, strings.TrimSpace(string(body))
+	}
+
+	BeforeEach(func() {
+		atomic.StoreInt32(&created, 0)
+		controller = &fakes.FakeJSONController{}
+		controller.CreateStub = func(c *gin.Context) (interface{}, error) {
+			body, _ := ioutil.ReadAll(c.Request.Body)
+			c.Header("Location", "/users/"+strconv.Itoa(int(atomic.AddInt32(&created, 1))))
+			return string(body), nil
+		}
+
+		config = thruster.Config{Idempotency: thruster.Idempotency{
+			Enabled: true,
+			Routes:  []string{"POST /users"},
+		}}
+	})
+
+	JustBeforeEach(func() {
+		engine := gin.New()
+		subject := thruster.NewServerWithEngine(config, engine)
+		subject.AddJSONResource("/users", controller)
+		testServer = httptest.NewServer(engine)
+	})
+
+	AfterEach(func() {
+		testServer.Close()
+	})
+
+	It("replays the first response to the retries", func() {
+		resp, body := post("key-1", "alice")
+		Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+		Expect(resp.Header.Get("Location")).To(Equal("/users/1"))
+		Expect(body).To(Equal(`"alice"`))
+
+		resp, body = post("key-1", "alice")
+		Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+		Expect(resp.Header.Get("Location")).To(Equal("/users/1"))
+		Expect(resp.Header.Get("Content-Type")).To(Equal("application/json; charset=utf-8"))
+		Expect(resp.Header.Get("Idempotent-Replayed")).To(Equal("true"))
+		Expect(body).To(Equal(`"alice"`))
+
+		Expect(controller.CreateCallCount()).To(Equal(1))
+	})
+
+	It("handles the requests with other keys, or none", func() {
+		post("key-1", "alice")
+		post("key-2", "alice")
+		post("", "alice")
+		post("", "alice")
+
+		Expect(controller.CreateCallCount()).To(Equal(4))
+	})
+
+	It("rejects reusing a key with another payload", func() {
+		post("key-1", "alice")
+
+		resp, body := post("key-1", "bob")
+		Expect(resp.StatusCode).To(Equal(http.StatusUnprocessableEntity))
+		Expect(body).To(ContainSubstring("Unprocessable Entity"))
+		Expect(controller.CreateCallCount()).To(Equal(1))
+	})
+
+	It("rejects the retries while the first request is in flight", func() {
+		release := make(chan struct{})
+		controller.CreateStub = func(c *gin.Context) (interface{}, error) {
+			atomic.AddInt32(&created, 1)
+			<-release
+			return "alice", nil
+		}
+
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			defer close(done)
+			post("key-1", "alice")
+		}()
+		Eventually(func() int32 { return atomic.LoadInt32(&created) }).Should(Equal(int32(1)))
+
+		resp, _ := post("key-1", "alice")
+		Expect(resp.StatusCode).To(Equal(http.StatusConflict))
+		Expect(resp.Header.Get("Retry-After")).To(Equal("1"))
+
+		close(release)
+		Eventually(done).Should(BeClosed())
+		resp, _ = post("key-1", "alice")
+		Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+	})
+
+	It("lets the requests that failed be retried", func() {
+		controller.CreateReturns(nil, thruster.ErrInternal)
+		controller.CreateStub = nil
+
+		resp, _ := post("key-1", "alice")
+		Expect(resp.StatusCode).To(Equal(http.StatusInternalServerError))
+
+		resp, _ = post("key-1", "alice")
+		Expect(resp.StatusCode).To(Equal(http.StatusInternalServerError))
+		Expect(controller.CreateCallCount()).To(Equal(2))
+	})
+
+	It("lets the requests that panicked be retried", func() {
+		controller.CreateStub = func(c *gin.Context) (interface{}, error) {
+			if atomic.AddInt32(&created, 1) == 1 {
+				panic("boom")
+			}
+			return "created", nil
+		}
+
+		resp, _ := post("key-1", "alice")
+		Expect(resp.StatusCode).To(Equal(http.StatusInternalServerError))
+
+		resp, body := post("key-1", "alice")
+		Expect(resp.StatusCode).To(Equal(http.StatusCreated))
+		Expect(body).To(Equal(`"created"`))
+	})
+
+	Context("when the handler outlives the timeout", func() {
+		BeforeEach(func() {
+			config.Timeouts.Handler = 20 * time.Millisecond
+			controller.CreateStub = func(c *gin.Context) (interface{}, error) {
+				time.Sleep(60 * time.Millisecond)
+				c.Header("Location", "/users/"+strconv.Itoa(int(atomic.AddInt32(&created, 1))))
+				return "created", nil
+			}
+		})
+
+		It("stores its response once it returns", func() {
+			resp, _ := post("key-1", "alice")
+			Expect(resp.StatusCode).To(Equal(http.StatusGatewayTimeout))
+
+			Eventually(func() int {
+				resp, _ := post("key-1", "alice")
+				return resp.StatusCode
+			}).Should(Equal(http.StatusCreated))
+
+			resp, body := post("key-1", "alice")
+			Expect(resp.Header.Get("Idempotent-Replayed")).To(Equal("true"))
+			Expect(resp.Header.Get("Location")).To(Equal("/users/1"))
+			Expect(body).To(Equal(`"created"`))
+			Expect(controller.CreateCallCount()).To(Equal(1))
+		})
+	})
+
+	Context("when the route isn't configured", func() {
+		BeforeEach(func() {
+			config.Idempotency.Routes = nil
+		})
+
+		It("ignores the key", func() {
+			post("key-1", "alice")
+			post("key-1", "alice")
+			Expect(controller.CreateCallCount()).To(Equal(2))
+		})
+	})
+})
+
+var _ = Describe("MemoryIdempotencyStore", func() {
+	It("expires the records", func() {
+		store := thruster.NewMemoryIdempotencyStore()
+		record := &thruster.IdempotencyRecord{Fingerprint: "a"}
+
+		existing, err := store.Reserve("key", record, time.Millisecond)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(existing).To(BeNil())
+
+		existing, _ = store.Reserve("key", &thruster.IdempotencyRecord{Fingerprint: "b"}, time.Minute)
+		Expect(existing).To(Equal(record))
+
+		time.Sleep(5 * time.Millisecond)
+		existing, _ = store.Reserve("key", &thruster.IdempotencyRecord{Fingerprint: "b"}, time.Minute)
+		Expect(existing).To(BeNil())
+	})
+})
@@ -0,0 +1,80 @@
+package thruster
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ipFilterNetworks is an IPFilter with its CIDRs parsed.
+type ipFilterNetworks struct {
+	global ipRuleNetworks
+	routes map[string]ipRuleNetworks
+}
+
+// ipRuleNetworks is an IPFilterRule with its CIDRs parsed.
+type ipRuleNetworks struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+func (f IPFilter) networks() ipFilterNetworks {
+	networks := ipFilterNetworks{
+		global: IPFilterRule{Allow: f.Allow, Deny: f.Deny}.networks(),
+		routes: make(map[string]ipRuleNetworks, len(f.Routes)),
+	}
+	for prefix, rule := range f.Routes {
+		networks.routes[prefix] = rule.networks()
+	}
+	return networks
+}
+
+func (r IPFilterRule) networks() ipRuleNetworks {
+	return ipRuleNetworks{allow: parseCIDRs(r.Allow), deny: parseCIDRs(r.Deny)}
+}
+
+// allows reports if the rule lets ip through: it's not denied and, with an
+// allow list, allowed.
+func (r ipRuleNetworks) allows(ip string) bool {
+	if isTrusted(r.deny, ip) {
+		return false
+	}
+	return len(r.allow) == 0 || isTrusted(r.allow, ip)
+}
+
+// routeRule returns the rule of the longest route prefix matching path, on
+// path segments, so "/users" matches "/users/:id" but not "/usersettings".
+func (f ipFilterNetworks) routeRule(path string) (ipRuleNetworks, bool) {
+	var rule ipRuleNetworks
+	longest := -1
+	for prefix, candidate := range f.routes {
+		trimmed := strings.TrimSuffix(prefix, "/")
+		if path != trimmed && !strings.HasPrefix(path, trimmed+"/") {
+			continue
+		}
+		if len(trimmed) > longest {
+			rule, longest = candidate, len(trimmed)
+		}
+	}
+	return rule, longest >= 0
+}
+
+// filterIP rejects the clients not allowed by the global rule or by the
+// rule of the route prefix with a 403, before the authentication.
+func (s *Server) filterIP(c *gin.Context) {
+	filter := s.currentNetworks().ipFilter
+	ip := ClientIP(c)
+	path := RoutePath(c)
+
+	allowed := filter.global.allows(ip)
+	if rule, found := filter.routeRule(path); allowed && found {
+		allowed = rule.allows(ip)
+	}
+
+	if !allowed {
+		s.serverMetrics.ipDenied.Inc(path)
+		abortWithError(c, http.StatusForbidden, ErrForbidden)
+	}
+}
@@ -0,0 +1,106 @@
+package thruster_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tscolari/thruster"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("IP filter", func() {
+	var subject *thruster.Server
+	var config thruster.Config
+	var testServer *httptest.Server
+
+	get := func(path string) *http.Response {
+		return makeSimpleRequest(thruster.GET, testServer.URL+path)
+	}
+
+	BeforeEach(func() {
+		config = thruster.Config{Port: 8080}
+	})
+
+	JustBeforeEach(func() {
+		engine := gin.New()
+		subject = thruster.NewServerWithEngine(config, engine)
+		subject.AddHandler(thruster.GET, "/test", func(c *gin.Context) {
+			c.String(200, "OK")
+		})
+		subject.AddJSONResource("/users", corsController{})
+		subject.AddHandler(thruster.GET, "/usersettings", func(c *gin.Context) {
+			c.String(200, "OK")
+		})
+		testServer = httptest.NewServer(engine)
+	})
+
+	AfterEach(func() {
+		testServer.Close()
+	})
+
+	It("lets everyone through by default", func() {
+		Expect(get("/test").StatusCode).To(Equal(http.StatusOK))
+	})
+
+	Context("with a global deny list", func() {
+		BeforeEach(func() {
+			config.IPFilter.Deny = []string{"127.0.0.0/8"}
+		})
+
+		It("rejects the denied clients with a 403", func() {
+			resp := get("/users")
+			Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+
+			body := map[string]string{}
+			Expect(json.NewDecoder(resp.Body).Decode(&body)).To(Succeed())
+			Expect(body["error"]).To(Equal(thruster.ErrForbidden.Error()))
+		})
+	})
+
+	Context("with a route prefix allow list", func() {
+		BeforeEach(func() {
+			config.IPFilter.Routes = map[string]thruster.IPFilterRule{
+				"/users": {Allow: []string{"10.0.0.0/8"}},
+			}
+		})
+
+		It("applies it to the routes under the prefix", func() {
+			Expect(get("/users").StatusCode).To(Equal(http.StatusForbidden))
+			Expect(get("/users/1").StatusCode).To(Equal(http.StatusForbidden))
+			Expect(get("/usersettings").StatusCode).To(Equal(http.StatusOK))
+			Expect(get("/test").StatusCode).To(Equal(http.StatusOK))
+		})
+
+		It("is evaluated before the authentication", func() {
+			config := config
+			config.HTTPAuth = []thruster.HTTPAuth{thruster.NewHTTPAuth("user", "secret")}
+			Expect(subject.Reload(config)).To(Succeed())
+
+			Expect(get("/users").StatusCode).To(Equal(http.StatusForbidden))
+			Expect(get("/test").StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("is hot reloaded", func() {
+			config := config
+			config.IPFilter.Routes = map[string]thruster.IPFilterRule{
+				"/users": {Allow: []string{"127.0.0.1"}},
+			}
+			Expect(subject.Reload(config)).To(Succeed())
+
+			Expect(get("/users").StatusCode).To(Equal(http.StatusOK))
+		})
+	})
+
+	Describe("validation", func() {
+		It("rejects invalid CIDRs", func() {
+			config := thruster.Config{Port: 8080, IPFilter: thruster.IPFilter{
+				Routes: map[string]thruster.IPFilterRule{"/admin": {Allow: []string{"internal"}}},
+			}}
+			Expect(config.Validate()).To(MatchError(ContainSubstring("ip_filter.routes[/admin].allow[0]")))
+		})
+	})
+})
@@ -0,0 +1,32 @@
+package thruster
+
+import (
+	"fmt"
+	"os"
+)
+
+const (
+	LogLevelInfo  = "info"
+	LogLevelWarn  = "warn"
+	LogLevelError = "error"
+)
+
+var logLevels = map[string]int{LogLevelInfo: 0, LogLevelWarn: 1, LogLevelError: 2}
+
+// logf writes a thruster message to stderr, unless its level is below the
+// configured LogLevel.
+func (s *Server) logf(level, format string, args ...interface{}) {
+	if !s.logs(level) {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "[thruster] "+format+"\n", args...)
+}
+
+// logs reports if the messages of level are written.
+func (s *Server) logs(level string) bool {
+	configured := s.currentConfig().LogLevel
+	if configured == "" {
+		configured = LogLevelInfo
+	}
+	return logLevels[level] >= logLevels[configured]
+}
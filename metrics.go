@@ -0,0 +1,246 @@
+package thruster
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultLatencyBuckets are the histogram buckets, in seconds, used for
+// request durations.
+var DefaultLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// DefaultSizeBuckets are the histogram buckets, in bytes, used for
+// response sizes.
+var DefaultSizeBuckets = []float64{100, 1000, 10000, 100000, 1000000, 10000000}
+
+// MetricsRegistry holds counters, gauges and histograms and writes them in
+// the Prometheus text exposition format.
+type MetricsRegistry struct {
+	mutex   sync.RWMutex
+	metrics map[string]*metric
+}
+
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{metrics: map[string]*metric{}}
+}
+
+type Counter struct{ metric *metric }
+type Gauge struct{ metric *metric }
+type Histogram struct{ metric *metric }
+
+// NewCounter registers a counter, or returns the one already registered
+// with the same name. labels are the label names; the values are given
+// in the same order on every update.
+func (r *MetricsRegistry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{r.register(name, help, "counter", labels, nil)}
+}
+
+func (r *MetricsRegistry) NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.register(name, help, "gauge", labels, nil)}
+}
+
+func (r *MetricsRegistry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	sorted := append([]float64{}, buckets...)
+	sort.Float64s(sorted)
+	return &Histogram{r.register(name, help, "histogram", labels, sorted)}
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) Add(value float64, labelValues ...string) {
+	if value < 0 {
+		panic("thruster: counters can't decrease")
+	}
+	c.metric.update(labelValues, func(s *series) { s.value += value })
+}
+
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.metric.update(labelValues, func(s *series) { s.value = value })
+}
+
+func (g *Gauge) Add(value float64, labelValues ...string) {
+	g.metric.update(labelValues, func(s *series) { s.value += value })
+}
+
+func (g *Gauge) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+func (g *Gauge) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.metric.update(labelValues, func(s *series) {
+		for i, bound := range h.metric.buckets {
+			if value <= bound {
+				s.buckets[i]++
+			}
+		}
+		s.count++
+		s.value += value
+	})
+}
+
+// WriteTo writes every metric in the Prometheus text format, version 0.0.4.
+func (r *MetricsRegistry) WriteTo(w io.Writer) (int64, error) {
+	r.mutex.RLock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	r.mutex.RUnlock()
+	sort.Strings(names)
+
+	counter := &countingWriter{writer: w}
+	buffer := bufio.NewWriter(counter)
+	for _, name := range names {
+		r.mutex.RLock()
+		metric := r.metrics[name]
+		r.mutex.RUnlock()
+		metric.writeTo(buffer)
+	}
+
+	err := buffer.Flush()
+	return counter.count, err
+}
+
+func (r *MetricsRegistry) register(name, help, kind string, labels []string, buckets []float64) *metric {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if existing, found := r.metrics[name]; found {
+		if existing.kind != kind || len(existing.labels) != len(labels) {
+			panic(fmt.Sprintf("thruster: metric %q already registered with a different type or labels", name))
+		}
+		return existing
+	}
+
+	m := &metric{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  map[string]*series{},
+	}
+	r.metrics[name] = m
+	return m
+}
+
+type metric struct {
+	mutex   sync.Mutex
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	series  map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	count       uint64
+	buckets     []uint64
+}
+
+func (m *metric) update(labelValues []string, update func(*series)) {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("thruster: metric %q expects %d label values, got %d", m.name, len(m.labels), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	s, found := m.series[key]
+	if !found {
+		s = &series{
+			labelValues: append([]string{}, labelValues...),
+			buckets:     make([]uint64, len(m.buckets)),
+		}
+		m.series[key] = s
+	}
+	update(s)
+}
+
+func (m *metric) writeTo(w io.Writer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := m.series[key]
+		if m.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, m.labelPairs(s.labelValues, "", ""), formatFloat(s.value))
+			continue
+		}
+
+		for i, bound := range m.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, m.labelPairs(s.labelValues, "le", formatFloat(bound)), s.buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, m.labelPairs(s.labelValues, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, m.labelPairs(s.labelValues, "", ""), formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, m.labelPairs(s.labelValues, "", ""), s.count)
+	}
+}
+
+func (m *metric) labelPairs(values []string, extraName, extraValue string) string {
+	pairs := []string{}
+	for i, name := range m.labels {
+		pairs = append(pairs, name+`="`+labelValueEscaper.Replace(values[i])+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+extraValue+`"`)
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+type countingWriter struct {
+	writer io.Writer
+	count  int64
+}
+
+func (w *countingWriter) Write(data []byte) (int, error) {
+	n, err := w.writer.Write(data)
+	w.count += int64(n)
+	return n, err
+}
@@ -0,0 +1,233 @@
+package thruster_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tscolari/thruster"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Metrics", func() {
+	Describe("MetricsRegistry", func() {
+		var registry *thruster.MetricsRegistry
+
+		BeforeEach(func() {
+			registry = thruster.NewMetricsRegistry()
+		})
+
+		exposition := func() string {
+			buffer := &bytes.Buffer{}
+			_, err := registry.WriteTo(buffer)
+			Expect(err).ToNot(HaveOccurred())
+			return buffer.String()
+		}
+
+		It("writes counters and gauges in the Prometheus text format", func() {
+			counter := registry.NewCounter("jobs_total", "Jobs processed.", "queue")
+			counter.Inc("default")
+			counter.Add(2, "default")
+			counter.Inc(`say "hi"`)
+
+			gauge := registry.NewGauge("workers", "Busy workers.")
+			gauge.Set(5)
+			gauge.Dec()
+
+			Expect(exposition()).To(Equal(`# HELP jobs_total Jobs processed.
+# TYPE jobs_total counter
+jobs_total{queue="default"} 3
+jobs_total{queue="say \"hi\""} 1
+# HELP workers Busy workers.
+# TYPE workers gauge
+workers 4
+`))
+		})
+
+		It("writes histograms with cumulative buckets", func() {
+			histogram := registry.NewHistogram("duration_seconds", "Duration.", []float64{1, 0.1})
+			histogram.Observe(0.05)
+			histogram.Observe(0.5)
+			histogram.Observe(3)
+
+			Expect(exposition()).To(Equal(`# HELP duration_seconds Duration.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{le="0.1"} 1
+duration_seconds_bucket{le="1"} 2
+duration_seconds_bucket{le="+Inf"} 3
+duration_seconds_sum 3.55
+duration_seconds_count 3
+`))
+		})
+
+		It("returns the existing metric when registered twice", func() {
+			registry.NewCounter("jobs_total", "Jobs processed.").Inc()
+			registry.NewCounter("jobs_total", "Jobs processed.").Inc()
+			Expect(exposition()).To(ContainSubstring("jobs_total 2"))
+		})
+
+		It("panics on the wrong number of label values", func() {
+			counter := registry.NewCounter("jobs_total", "Jobs processed.", "queue")
+			Expect(func() { counter.Inc() }).To(Panic())
+		})
+	})
+
+	Describe("server metrics", func() {
+		var subject *thruster.Server
+		var config thruster.Config
+		var testServer *httptest.Server
+
+		scrape := func(url string) string {
+			resp := makeSimpleRequest(thruster.GET, url)
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header.Get("Content-Type")).To(ContainSubstring("text/plain; version=0.0.4"))
+			body, err := ioutil.ReadAll(resp.Body)
+			Expect(err).ToNot(HaveOccurred())
+			return string(body)
+		}
+
+		BeforeEach(func() {
+			config = thruster.Config{
+				Hostname: "localhost",
+				Metrics:  thruster.Metrics{Enabled: true},
+			}
+		})
+
+		JustBeforeEach(func() {
+			engine := gin.New()
+			subject = thruster.NewServerWithEngine(config, engine)
+			subject.AddHandler(thruster.GET, "/users/:id", func(c *gin.Context) {
+				c.String(200, "OK")
+			})
+			subject.AddJSONHandler(thruster.GET, "/missing", func(c *gin.Context) (interface{}, error) {
+				return nil, thruster.ErrNotFound
+			})
+			testServer = httptest.NewServer(engine)
+		})
+
+		AfterEach(func() {
+			testServer.Close()
+		})
+
+		It("exposes the request metrics labeled by route template", func() {
+			makeSimpleRequest(thruster.GET, testServer.URL+"/users/1")
+			makeSimpleRequest(thruster.GET, testServer.URL+"/users/2")
+			makeSimpleRequest(thruster.GET, testServer.URL+"/missing")
+
+			metrics := scrape(testServer.URL + "/metrics")
+			Expect(metrics).To(ContainSubstring(`thruster_http_requests_total{method="GET",route="/users/:id",status="2xx"} 2`))
+			Expect(metrics).To(ContainSubstring(`thruster_http_requests_total{method="GET",route="/missing",status="4xx"} 1`))
+			Expect(metrics).To(ContainSubstring(`thruster_http_request_duration_seconds_count{method="GET",route="/users/:id",status="2xx"} 2`))
+			Expect(metrics).To(ContainSubstring(`thruster_http_response_size_bytes_sum{method="GET",route="/users/:id"} 4`))
+			Expect(metrics).To(ContainSubstring(`thruster_http_requests_in_flight 1`))
+		})
+
+		It("exposes the application metrics", func() {
+			subject.Metrics().NewCounter("myapp_signups_total", "Signups.").Inc()
+			Expect(scrape(testServer.URL + "/metrics")).To(ContainSubstring("myapp_signups_total 1"))
+		})
+
+		Context("with HTTP auth", func() {
+			BeforeEach(func() {
+				config.HTTPAuth = []thruster.HTTPAuth{thruster.NewHTTPAuth("admin", "passwd")}
+			})
+
+			It("counts the auth failures", func() {
+				makeSimpleRequest(thruster.GET, testServer.URL+"/users/1")
+
+				request, err := http.NewRequest(thruster.GET, testServer.URL+"/metrics", nil)
+				Expect(err).ToNot(HaveOccurred())
+				request.SetBasicAuth("admin", "passwd")
+				resp, err := http.DefaultClient.Do(request)
+				Expect(err).ToNot(HaveOccurred())
+				body, err := ioutil.ReadAll(resp.Body)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(body)).To(ContainSubstring(`thruster_http_auth_failures_total{route="/users/:id"} 1`))
+			})
+		})
+
+		Context("with a custom path", func() {
+			BeforeEach(func() {
+				config.Metrics.Path = "/_metrics"
+			})
+
+			It("serves them on that path", func() {
+				Expect(scrape(testServer.URL + "/_metrics")).To(ContainSubstring("thruster_http_requests_in_flight"))
+			})
+		})
+
+		Context("on a separate listener", func() {
+			var port, metricsPort int
+
+			BeforeEach(func() {
+				port = rand.Intn(8000) + 3000
+				metricsPort = port + 1
+				config.Port = port
+				config.Metrics.Address = "localhost:" + strconv.Itoa(metricsPort)
+			})
+
+			It("serves them only there", func() {
+				startServer(subject, port)
+				Eventually(func() error {
+					_, err := http.Get("http://localhost:" + strconv.Itoa(metricsPort) + "/metrics")
+					return err
+				}).Should(Succeed())
+
+				Expect(scrape("http://localhost:" + strconv.Itoa(metricsPort) + "/metrics")).To(ContainSubstring("thruster_http_requests_in_flight"))
+
+				resp := makeSimpleRequest(thruster.GET, testServer.URL+"/metrics")
+				Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+			})
+
+			Context("when the main listener fails", func() {
+				var taken net.Listener
+
+				BeforeEach(func() {
+					var err error
+					taken, err = net.Listen("tcp", "localhost:0")
+					Expect(err).ToNot(HaveOccurred())
+					port = taken.Addr().(*net.TCPAddr).Port
+
+					free, err := net.Listen("tcp", "localhost:0")
+					Expect(err).ToNot(HaveOccurred())
+					metricsPort = free.Addr().(*net.TCPAddr).Port
+					free.Close()
+
+					config.Port = port
+					config.Metrics.Address = "localhost:" + strconv.Itoa(metricsPort)
+				})
+
+				AfterEach(func() {
+					taken.Close()
+				})
+
+				It("closes it too", func() {
+					Expect(subject.Run()).ToNot(Succeed())
+
+					Consistently(func() error {
+						_, err := http.Get("http://localhost:" + strconv.Itoa(metricsPort) + "/metrics")
+						return err
+					}, "200ms").Should(HaveOccurred())
+				})
+			})
+		})
+
+		Context("when disabled", func() {
+			BeforeEach(func() {
+				config.Metrics.Enabled = false
+			})
+
+			It("doesn't serve them", func() {
+				resp := makeSimpleRequest(thruster.GET, testServer.URL+"/metrics")
+				Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+			})
+		})
+	})
+})
@@ -0,0 +1,200 @@
+package thruster
+
+import (
+	"net"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	clientIPKey = "thruster.client_ip"
+	schemeKey   = "thruster.scheme"
+)
+
+// ClientIP returns the IP address of the client. Behind trusted proxies,
+// it's the address they forwarded the request for.
+func ClientIP(c *gin.Context) string {
+	if ip, ok := c.Get(clientIPKey); ok {
+		return ip.(string)
+	}
+	return remoteIP(c.Request.RemoteAddr)
+}
+
+// Scheme returns the scheme the client used, "http" or "https". Behind
+// trusted proxies, it's the one they forwarded.
+func Scheme(c *gin.Context) string {
+	if scheme, ok := c.Get(schemeKey); ok {
+		return scheme.(string)
+	}
+	if c.Request.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// resolveClient resolves the client IP and scheme of the request. The
+// Forwarded, or else X-Forwarded-For and X-Forwarded-Proto, headers are
+// only used when the peer is a trusted proxy. They're walked from the last
+// proxy back: the client is the closest address in them that's not a
+// trusted proxy, and the scheme the last one forwarded on the way there.
+func (s *Server) resolveClient(c *gin.Context) {
+	peer := remoteIP(c.Request.RemoteAddr)
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+
+	trusted := s.currentNetworks().trustedProxies
+	if isTrusted(trusted, peer) {
+		hops := forwardedHeaders(c.Request.Header)
+		client := peer
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(hops[i].address)
+			if ip == nil {
+				break
+			}
+			client = ip.String()
+			if hops[i].proto == "http" || hops[i].proto == "https" {
+				scheme = hops[i].proto
+			}
+			if !isTrusted(trusted, client) {
+				break
+			}
+		}
+		peer = client
+	}
+
+	c.Set(clientIPKey, peer)
+	c.Set(schemeKey, scheme)
+}
+
+// forwardedHop is an element of the forwarding headers: the address a proxy
+// received the request from, and the scheme it was received with, if known.
+type forwardedHop struct {
+	address string
+	proto   string
+}
+
+// forwardedHeaders returns the forwarding hops, from the client to the last
+// proxy. The X-Forwarded-Proto values are matched to the X-Forwarded-For
+// ones from the right, as proxies that only set their own leave one.
+func forwardedHeaders(header map[string][]string) []forwardedHop {
+	if values := header["Forwarded"]; len(values) > 0 {
+		hops := []forwardedHop{}
+		for _, element := range strings.Split(strings.Join(values, ","), ",") {
+			hop := forwardedHop{}
+			for _, pair := range strings.Split(element, ";") {
+				parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(parts) != 2 {
+					continue
+				}
+
+				value := strings.Trim(parts[1], `"`)
+				switch strings.ToLower(parts[0]) {
+				case "for":
+					hop.address = forwardedNode(value)
+				case "proto":
+					hop.proto = strings.ToLower(value)
+				}
+			}
+			hops = append(hops, hop)
+		}
+		return hops
+	}
+
+	hops := []forwardedHop{}
+	for _, value := range header["X-Forwarded-For"] {
+		for _, address := range strings.Split(value, ",") {
+			hops = append(hops, forwardedHop{address: forwardedNode(strings.TrimSpace(address))})
+		}
+	}
+
+	protos := []string{}
+	for _, value := range header["X-Forwarded-Proto"] {
+		for _, proto := range strings.Split(value, ",") {
+			protos = append(protos, strings.ToLower(strings.TrimSpace(proto)))
+		}
+	}
+	for i, j := len(hops)-1, len(protos)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		hops[i].proto = protos[j]
+	}
+	return hops
+}
+
+// forwardedNode strips the port and IPv6 brackets of a forwarded address,
+// e.g. `[2001:db8::17]:4711`.
+func forwardedNode(node string) string {
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+	return strings.Trim(node, "[]")
+}
+
+func remoteIP(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
+
+// configNetworks holds the CIDRs of a config, parsed once when it's applied
+// rather than on every request.
+type configNetworks struct {
+	trustedProxies []*net.IPNet
+	ipFilter       ipFilterNetworks
+}
+
+func newConfigNetworks(config Config) *configNetworks {
+	return &configNetworks{
+		trustedProxies: parseCIDRs(config.TrustedProxies),
+		ipFilter:       config.IPFilter.networks(),
+	}
+}
+
+func (s *Server) currentNetworks() *configNetworks {
+	s.configMutex.RLock()
+	defer s.configMutex.RUnlock()
+	return s.networks
+}
+
+// parseCIDRs parses the valid CIDRs and IPs, IPs being single address
+// networks.
+func parseCIDRs(values []string) []*net.IPNet {
+	networks := []*net.IPNet{}
+	for _, value := range values {
+		if network, err := parseCIDR(value); err == nil {
+			networks = append(networks, network)
+		}
+	}
+	return networks
+}
+
+func parseCIDR(value string) (*net.IPNet, error) {
+	if !strings.Contains(value, "/") {
+		if ip := net.ParseIP(value); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+		}
+	}
+
+	_, network, err := net.ParseCIDR(value)
+	return network, err
+}
+
+func isTrusted(networks []*net.IPNet, address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
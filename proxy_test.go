@@ -0,0 +1,101 @@
+package thruster_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tscolari/thruster"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Trusted proxies", func() {
+	var config thruster.Config
+	var testServer *httptest.Server
+	var clientIP, scheme string
+
+	get := func(headers map[string]string) {
+		request, err := http.NewRequest(thruster.GET, testServer.URL+"/test", nil)
+		Expect(err).ToNot(HaveOccurred())
+		for key, value := range headers {
+			request.Header.Set(key, value)
+		}
+		_, err = http.DefaultClient.Do(request)
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		config = thruster.Config{}
+	})
+
+	JustBeforeEach(func() {
+		engine := gin.New()
+		subject := thruster.NewServerWithEngine(config, engine)
+		subject.AddHandler(thruster.GET, "/test", func(c *gin.Context) {
+			clientIP = thruster.ClientIP(c)
+			scheme = thruster.Scheme(c)
+			c.String(200, "OK")
+		})
+		testServer = httptest.NewServer(engine)
+	})
+
+	AfterEach(func() {
+		testServer.Close()
+	})
+
+	Context("without trusted proxies", func() {
+		It("ignores the forwarding headers", func() {
+			get(map[string]string{"X-Forwarded-For": "203.0.113.7", "X-Forwarded-Proto": "https"})
+			Expect(clientIP).To(Equal("127.0.0.1"))
+			Expect(scheme).To(Equal("http"))
+		})
+	})
+
+	Context("behind a trusted proxy", func() {
+		BeforeEach(func() {
+			config.TrustedProxies = []string{"127.0.0.1", "10.0.0.0/8"}
+		})
+
+		It("uses X-Forwarded-For and X-Forwarded-Proto", func() {
+			get(map[string]string{"X-Forwarded-For": "203.0.113.7", "X-Forwarded-Proto": "https"})
+			Expect(clientIP).To(Equal("203.0.113.7"))
+			Expect(scheme).To(Equal("https"))
+		})
+
+		It("skips the trusted proxies of the chain", func() {
+			get(map[string]string{"X-Forwarded-For": "198.51.100.1, 203.0.113.7, 10.1.2.3"})
+			Expect(clientIP).To(Equal("203.0.113.7"))
+		})
+
+		It("uses the Forwarded header", func() {
+			get(map[string]string{"Forwarded": `for="[2001:db8:cafe::17]:4711";proto=https, for=10.1.2.3`})
+			Expect(clientIP).To(Equal("2001:db8:cafe::17"))
+			Expect(scheme).To(Equal("https"))
+		})
+
+		It("takes the scheme forwarded for the client, not the spoofed ones", func() {
+			get(map[string]string{"X-Forwarded-For": "198.51.100.1, 203.0.113.7", "X-Forwarded-Proto": "https, http"})
+			Expect(clientIP).To(Equal("203.0.113.7"))
+			Expect(scheme).To(Equal("http"))
+
+			get(map[string]string{"Forwarded": "for=198.51.100.1;proto=https, for=203.0.113.7;proto=http, for=10.1.2.3"})
+			Expect(clientIP).To(Equal("203.0.113.7"))
+			Expect(scheme).To(Equal("http"))
+		})
+
+		It("uses the peer without forwarding headers", func() {
+			get(nil)
+			Expect(clientIP).To(Equal("127.0.0.1"))
+			Expect(scheme).To(Equal("http"))
+		})
+	})
+
+	Describe("validation", func() {
+		It("rejects invalid CIDRs", func() {
+			config := thruster.Config{Port: 8080, TrustedProxies: []string{"10.0.0.0/33"}}
+			Expect(config.Validate()).To(MatchError(ContainSubstring("trusted_proxies[0]")))
+		})
+	})
+})
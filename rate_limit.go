@@ -0,0 +1,230 @@
+package thruster
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	RateLimitByIP    = "ip"
+	RateLimitByUser  = "user"
+	RateLimitByRoute = "route"
+	// RateLimitByHeader is followed by the header name, e.g.
+	// "header:X-API-Key".
+	RateLimitByHeader = "header:"
+
+	defaultRateLimitPeriod = time.Second
+)
+
+// RateLimitStore keeps the token buckets of the rate limiter. The default
+// one is in memory; a shared one limits the clients across instances.
+type RateLimitStore interface {
+	// Take takes a token from the bucket of key, which holds up to
+	// rule.Burst tokens, refilled at rule.Requests per rule.Period.
+	Take(key string, rule RateLimitRule) (RateLimitResult, error)
+}
+
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the time until the bucket is full again, and RetryAfter the
+	// time until the next token, when the request wasn't allowed.
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// SetRateLimitStore keeps the rate limits in store, instead of in memory.
+func (s *Server) SetRateLimitStore(store RateLimitStore) {
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+	s.rateLimitStore = store
+}
+
+func (s *Server) rateLimiter() RateLimitStore {
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+	if s.rateLimitStore == nil {
+		s.rateLimitStore = NewMemoryRateLimitStore()
+	}
+	return s.rateLimitStore
+}
+
+// rule returns the rule of route, with the unset fields of the route
+// override taken from the default rule.
+func (r RateLimit) rule(route Route) (string, RateLimitRule) {
+	rule := RateLimitRule{Requests: r.Requests, Period: r.Period, Burst: r.Burst, Key: r.Key}
+	scope := ""
+
+	routeKey := route.Method + " " + route.Path
+	if override, found := r.Routes[routeKey]; found {
+		scope = routeKey
+		rule.Requests = override.Requests
+		rule.Burst = override.Burst
+		if override.Period > 0 {
+			rule.Period = override.Period
+		}
+		if override.Key != "" {
+			rule.Key = override.Key
+		}
+	}
+
+	if rule.Period <= 0 {
+		rule.Period = defaultRateLimitPeriod
+	}
+	if rule.Burst <= 0 {
+		rule.Burst = rule.Requests
+	}
+	if rule.Key == "" {
+		rule.Key = RateLimitByIP
+	}
+	return scope, rule
+}
+
+// rateLimitClients applies the rules not keyed by user, before the HTTP
+// auth, so failed logins count too.
+func (s *Server) rateLimitClients(c *gin.Context) {
+	s.rateLimit(c, false)
+}
+
+// rateLimitUsers applies the rules keyed by user, once authenticated.
+func (s *Server) rateLimitUsers(c *gin.Context) {
+	s.rateLimit(c, true)
+}
+
+// rateLimit rejects the requests over the limit of the route with a 429.
+// The requests of the default rule share a bucket per client across routes;
+// the route overrides have their own.
+func (s *Server) rateLimit(c *gin.Context, byUser bool) {
+	config := s.currentConfig().RateLimit
+	route, _ := CurrentRoute(c)
+	if !config.Enabled || route.unthrottled {
+		return
+	}
+
+	scope, rule := config.rule(route)
+	if rule.Requests <= 0 || (rule.Key == RateLimitByUser) != byUser {
+		return
+	}
+
+	result, err := s.rateLimiter().Take(scope+"|"+rateLimitKey(c, rule.Key, route), rule)
+	if err != nil {
+		// Lets the request through rather than failing on a store outage.
+		s.logf(LogLevelWarn, "rate limit: %s", err)
+		return
+	}
+
+	c.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+	c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d;burst=%d", rule.Requests, ceilSeconds(rule.Period), rule.Burst))
+
+	if !result.Allowed {
+		s.serverMetrics.rateLimited.Inc(route.Path)
+		c.Header("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+		abortWithError(c, http.StatusTooManyRequests, ErrTooManyRequests)
+	}
+}
+
+// rateLimitKey identifies the client of the request, or its route for the
+// route key. The user and header keys fall back to the client IP for
+// requests without them.
+func rateLimitKey(c *gin.Context, key string, route Route) string {
+	switch {
+	case key == RateLimitByUser:
+		if user := AuthenticatedUser(c); user != "" {
+			return "user:" + user
+		}
+	case key == RateLimitByRoute:
+		return "route:" + route.Method + " " + route.Path
+	case strings.HasPrefix(key, RateLimitByHeader):
+		if value := c.Request.Header.Get(strings.TrimPrefix(key, RateLimitByHeader)); value != "" {
+			return key + ":" + value
+		}
+	}
+	return "ip:" + ClientIP(c)
+}
+
+func ceilSeconds(duration time.Duration) int {
+	return int(math.Ceil(duration.Seconds()))
+}
+
+// MemoryRateLimitStore keeps the token buckets in memory, dropping the ones
+// that are full again.
+type MemoryRateLimitStore struct {
+	mutex   sync.Mutex
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+	swept   time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets: map[string]*tokenBucket{},
+		now:     time.Now,
+	}
+}
+
+func (m *MemoryRateLimitStore) Take(key string, rule RateLimitRule) (RateLimitResult, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+
+	capacity := float64(rule.Burst)
+	perSecond := float64(rule.Requests) / rule.Period.Seconds()
+
+	bucket, found := m.buckets[key]
+	if !found {
+		bucket = &tokenBucket{tokens: capacity, updated: now}
+		m.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(capacity, bucket.tokens+now.Sub(bucket.updated).Seconds()*perSecond)
+	bucket.updated = now
+
+	result := RateLimitResult{Limit: rule.Burst}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsDuration((1 - bucket.tokens) / perSecond)
+	}
+
+	result.Remaining = int(bucket.tokens)
+	result.Reset = secondsDuration((capacity - bucket.tokens) / perSecond)
+	bucket.full = now.Add(result.Reset)
+	return result, nil
+}
+
+// sweep drops the full buckets, at most once a minute.
+func (m *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(m.swept) < time.Minute {
+		return
+	}
+	m.swept = now
+
+	for key, bucket := range m.buckets {
+		if !now.Before(bucket.full) {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
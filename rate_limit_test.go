@@ -0,0 +1,223 @@
+package thruster_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tscolari/thruster"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type failingRateLimitStore struct{}
+
+func (failingRateLimitStore) Take(string, thruster.RateLimitRule) (thruster.RateLimitResult, error) {
+	return thruster.RateLimitResult{}, errors.New("store unavailable")
+}
+
+var _ = Describe("Rate limit", func() {
+	var subject *thruster.Server
+	var config thruster.Config
+	var testServer *httptest.Server
+
+	get := func(path string, headers map[string]string) *http.Response {
+		request, err := http.NewRequest(thruster.GET, testServer.URL+path, nil)
+		Expect(err).ToNot(HaveOccurred())
+		for key, value := range headers {
+			request.Header.Set(key, value)
+		}
+		resp, err := http.DefaultClient.Do(request)
+		Expect(err).ToNot(HaveOccurred())
+		return resp
+	}
+
+	BeforeEach(func() {
+		config = thruster.Config{
+			RateLimit: thruster.RateLimit{
+				Enabled:  true,
+				Requests: 2,
+				Period:   time.Minute,
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		engine := gin.New()
+		subject = thruster.NewServerWithEngine(config, engine)
+		subject.AddHandler(thruster.GET, "/test", func(c *gin.Context) {
+			c.String(200, "OK")
+		})
+		subject.AddJSONHandler(thruster.GET, "/other", func(c *gin.Context) (interface{}, error) {
+			return "OK", nil
+		})
+		subject.AddHandler(thruster.GET, "/login", func(c *gin.Context) {
+			c.String(200, "OK")
+		})
+		testServer = httptest.NewServer(engine)
+	})
+
+	AfterEach(func() {
+		testServer.Close()
+	})
+
+	It("limits the requests of each client", func() {
+		resp := get("/test", nil)
+		Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		Expect(resp.Header.Get("RateLimit-Limit")).To(Equal("2"))
+		Expect(resp.Header.Get("RateLimit-Remaining")).To(Equal("1"))
+		Expect(resp.Header.Get("RateLimit-Policy")).To(Equal("2;w=60;burst=2"))
+
+		Expect(get("/test", nil).StatusCode).To(Equal(http.StatusOK))
+
+		resp = get("/other", nil)
+		Expect(resp.StatusCode).To(Equal(http.StatusTooManyRequests))
+		Expect(resp.Header.Get("RateLimit-Remaining")).To(Equal("0"))
+		Expect(resp.Header.Get("Retry-After")).To(Equal("30"))
+
+		body := map[string]string{}
+		Expect(json.NewDecoder(resp.Body).Decode(&body)).To(Succeed())
+		Expect(body["error"]).To(Equal(thruster.ErrTooManyRequests.Error()))
+	})
+
+	Context("with a route override", func() {
+		BeforeEach(func() {
+			config.RateLimit.Routes = map[string]thruster.RateLimitRule{
+				"GET /login": {Requests: 1},
+				"GET /other": {Requests: 0},
+			}
+		})
+
+		It("gives the route its own bucket", func() {
+			Expect(get("/login", nil).StatusCode).To(Equal(http.StatusOK))
+			Expect(get("/login", nil).StatusCode).To(Equal(http.StatusTooManyRequests))
+			Expect(get("/test", nil).StatusCode).To(Equal(http.StatusOK))
+		})
+
+		It("doesn't limit routes with 0 requests", func() {
+			for i := 0; i < 5; i++ {
+				Expect(get("/other", nil).StatusCode).To(Equal(http.StatusOK))
+			}
+		})
+	})
+
+	Context("keyed by route", func() {
+		BeforeEach(func() {
+			config.RateLimit.Key = thruster.RateLimitByRoute
+		})
+
+		It("gives each route a bucket of its own", func() {
+			Expect(get("/test", nil).StatusCode).To(Equal(http.StatusOK))
+			Expect(get("/test", nil).StatusCode).To(Equal(http.StatusOK))
+			Expect(get("/test", nil).StatusCode).To(Equal(http.StatusTooManyRequests))
+			Expect(get("/other", nil).StatusCode).To(Equal(http.StatusOK))
+		})
+	})
+
+	Context("keyed by header", func() {
+		BeforeEach(func() {
+			config.RateLimit.Key = "header:X-API-Key"
+		})
+
+		It("limits each key apart", func() {
+			first := map[string]string{"X-API-Key": "first"}
+			second := map[string]string{"X-API-Key": "second"}
+
+			Expect(get("/test", first).StatusCode).To(Equal(http.StatusOK))
+			Expect(get("/test", first).StatusCode).To(Equal(http.StatusOK))
+			Expect(get("/test", first).StatusCode).To(Equal(http.StatusTooManyRequests))
+			Expect(get("/test", second).StatusCode).To(Equal(http.StatusOK))
+		})
+	})
+
+	Context("keyed by user", func() {
+		BeforeEach(func() {
+			config.RateLimit.Key = "user"
+			config.HTTPAuth = []thruster.HTTPAuth{
+				thruster.NewHTTPAuth("first", "secret"),
+				thruster.NewHTTPAuth("second", "secret"),
+			}
+		})
+
+		It("limits each user apart", func() {
+			request := func(username string) int {
+				request, _ := http.NewRequest(thruster.GET, testServer.URL+"/test", nil)
+				request.SetBasicAuth(username, "secret")
+				resp, err := http.DefaultClient.Do(request)
+				Expect(err).ToNot(HaveOccurred())
+				return resp.StatusCode
+			}
+
+			Expect(request("first")).To(Equal(http.StatusOK))
+			Expect(request("first")).To(Equal(http.StatusOK))
+			Expect(request("first")).To(Equal(http.StatusTooManyRequests))
+			Expect(request("second")).To(Equal(http.StatusOK))
+		})
+	})
+
+	Context("with HTTP auth", func() {
+		BeforeEach(func() {
+			config.HTTPAuth = []thruster.HTTPAuth{thruster.NewHTTPAuth("user", "secret")}
+		})
+
+		It("counts the failed logins", func() {
+			Expect(get("/test", nil).StatusCode).To(Equal(http.StatusUnauthorized))
+			Expect(get("/test", nil).StatusCode).To(Equal(http.StatusUnauthorized))
+			Expect(get("/test", nil).StatusCode).To(Equal(http.StatusTooManyRequests))
+		})
+	})
+
+	Context("with the health endpoints", func() {
+		BeforeEach(func() {
+			config.Health.Enabled = true
+		})
+
+		It("doesn't limit them", func() {
+			for i := 0; i < 5; i++ {
+				Expect(get("/readyz", nil).StatusCode).To(Equal(http.StatusOK))
+			}
+		})
+	})
+
+	Context("when the store fails", func() {
+		JustBeforeEach(func() {
+			subject.SetRateLimitStore(failingRateLimitStore{})
+		})
+
+		It("lets the requests through", func() {
+			for i := 0; i < 3; i++ {
+				Expect(get("/test", nil).StatusCode).To(Equal(http.StatusOK))
+			}
+		})
+	})
+
+	Describe("MemoryRateLimitStore", func() {
+		It("refills the bucket over time", func() {
+			store := thruster.NewMemoryRateLimitStore()
+			rule := thruster.RateLimitRule{Requests: 1, Period: 20 * time.Millisecond, Burst: 1}
+
+			result, err := store.Take("key", rule)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.Allowed).To(BeTrue())
+
+			result, _ = store.Take("key", rule)
+			Expect(result.Allowed).To(BeFalse())
+			Expect(result.RetryAfter).To(BeNumerically("<=", 20*time.Millisecond))
+
+			time.Sleep(25 * time.Millisecond)
+			result, _ = store.Take("key", rule)
+			Expect(result.Allowed).To(BeTrue())
+		})
+	})
+
+	Describe("validation", func() {
+		It("rejects unknown keys", func() {
+			config := thruster.Config{Port: 8080, RateLimit: thruster.RateLimit{Key: "cookie"}}
+			Expect(config.Validate()).To(MatchError(ContainSubstring("rate_limit.key")))
+		})
+	})
+})
@@ -0,0 +1,160 @@
+package thruster
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PanicEvent is sent to the OnPanic callbacks when a handler panics.
+type PanicEvent struct {
+	Context *gin.Context
+	Route   Route
+	Value   interface{}
+	Stack   []byte
+}
+
+// OnPanic registers a callback to be called after a handler panic is
+// recovered, e.g. to report it to an error tracker.
+func (s *Server) OnPanic(callback func(PanicEvent)) {
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+	s.panicCallbacks = append(s.panicCallbacks, callback)
+}
+
+// recoverPanic turns handler panics into 500s, with the JSON error shape on
+// JSON routes. The panic is logged with its stack and the request ID, and
+// sent to the OnPanic callbacks. http.ErrAbortHandler is re-panicked, so
+// net/http aborts the response as intended.
+func (s *Server) recoverPanic(c *gin.Context) {
+	defer func() {
+		value := recover()
+		if value == nil {
+			return
+		}
+		if value == http.ErrAbortHandler {
+			panic(value)
+		}
+
+		route, _ := CurrentRoute(c)
+		event := PanicEvent{Context: c, Route: route, Value: value, Stack: debug.Stack()}
+		s.logPanic(event)
+		s.serverMetrics.panics.Inc(RoutePath(c))
+
+		if !c.Writer.Written() {
+			if event.Route.JSON {
+				c.JSON(http.StatusInternalServerError, jsonError(c, ErrInternal))
+			} else {
+				c.Writer.WriteHeader(http.StatusInternalServerError)
+			}
+		}
+		c.Abort()
+
+		s.configMutex.RLock()
+		callbacks := s.panicCallbacks
+		s.configMutex.RUnlock()
+
+		for _, callback := range callbacks {
+			callback(event)
+		}
+	}()
+
+	c.Next()
+}
+
+const abortedKey = "thruster.aborted"
+
+// fallbackRecovery is gin's recovery, for the panics out of the routes'
+// recoverPanic, e.g. in the OnPanic callbacks. http.ErrAbortHandler, which
+// catchAbort hands over, still aborts the response.
+func fallbackRecovery() gin.HandlerFunc {
+	recovery := gin.Recovery()
+	return func(c *gin.Context) {
+		recovery(c)
+		if _, aborted := c.Get(abortedKey); aborted {
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// catchAbort keeps http.ErrAbortHandler from gin's recovery. It must follow
+// fallbackRecovery.
+func catchAbort(c *gin.Context) {
+	defer func() {
+		value := recover()
+		if value == http.ErrAbortHandler {
+			c.Set(abortedKey, true)
+			c.Abort()
+			return
+		}
+		if value != nil {
+			panic(value)
+		}
+	}()
+
+	c.Next()
+}
+
+// logPanic writes the panic to the access log output, in its format, or to
+// stderr as JSON when the access log is disabled.
+func (s *Server) logPanic(event PanicEvent) {
+	c := event.Context
+	fields := []logField{
+		{"time", time.Now().Format(time.RFC3339Nano)},
+		{"level", "error"},
+		{"msg", "panic recovered"},
+		{"panic", fmt.Sprint(event.Value)},
+		{"method", c.Request.Method},
+		{"path", c.Request.URL.RequestURI()},
+		{"route", event.Route.Path},
+		{"request_id", RequestID(c)},
+		{"stack", string(event.Stack)},
+	}
+
+	config := s.currentConfig().AccessLog
+	line := jsonLine(fields)
+	if config.Enabled && config.Format == LogFormatLogfmt {
+		line = logfmtLine(fields)
+	}
+	if config.Enabled && s.writeAccessLog(config, line) == nil {
+		return
+	}
+	os.Stderr.Write(line)
+}
+
+type logField struct {
+	key   string
+	value interface{}
+}
+
+func jsonLine(fields []logField) []byte {
+	buffer := &bytes.Buffer{}
+	buffer.WriteByte('{')
+	for i, field := range fields {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		keyJSON, _ := json.Marshal(field.key)
+		valueJSON, _ := json.Marshal(field.value)
+		buffer.Write(keyJSON)
+		buffer.WriteByte(':')
+		buffer.Write(valueJSON)
+	}
+	buffer.WriteString("}\n")
+	return buffer.Bytes()
+}
+
+func logfmtLine(fields []logField) []byte {
+	pairs := make([]string, len(fields))
+	for i, field := range fields {
+		pairs[i] = field.key + "=" + logfmtValue(field.value)
+	}
+	return []byte(strings.Join(pairs, " ") + "\n")
+}
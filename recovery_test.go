@@ -0,0 +1,139 @@
+package thruster_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tscolari/thruster"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Panic recovery", func() {
+	var subject *thruster.Server
+	var config thruster.Config
+	var testServer *httptest.Server
+	var dir, logPath string
+	var events []thruster.PanicEvent
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "thruster")
+		Expect(err).ToNot(HaveOccurred())
+		logPath = filepath.Join(dir, "access.log")
+		events = nil
+
+		config = thruster.Config{
+			AccessLog: thruster.AccessLog{Enabled: true, Output: logPath},
+		}
+	})
+
+	JustBeforeEach(func() {
+		engine := gin.New()
+		subject = thruster.NewServerWithEngine(config, engine)
+		subject.OnPanic(func(event thruster.PanicEvent) {
+			events = append(events, event)
+		})
+		subject.AddJSONHandler(thruster.GET, "/json", func(c *gin.Context) (interface{}, error) {
+			panic("boom")
+		})
+		subject.AddHandler(thruster.GET, "/plain", func(c *gin.Context) {
+			panic("boom")
+		})
+		subject.AddHandler(thruster.GET, "/abort", func(c *gin.Context) {
+			panic(http.ErrAbortHandler)
+		})
+		testServer = httptest.NewServer(engine)
+	})
+
+	AfterEach(func() {
+		testServer.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("responds to JSON routes with the JSON error shape", func() {
+		resp := makeSimpleRequest(thruster.GET, testServer.URL+"/json")
+		Expect(resp.StatusCode).To(Equal(http.StatusInternalServerError))
+
+		body := map[string]string{}
+		Expect(json.NewDecoder(resp.Body).Decode(&body)).To(Succeed())
+		Expect(body["error"]).To(Equal(thruster.ErrInternal.Error()))
+		Expect(body["request_id"]).To(Equal(resp.Header.Get("X-Request-ID")))
+	})
+
+	It("responds to other routes with a bare 500", func() {
+		resp := makeSimpleRequest(thruster.GET, testServer.URL+"/plain")
+		Expect(resp.StatusCode).To(Equal(http.StatusInternalServerError))
+	})
+
+	It("logs the panic with its stack and the request ID", func() {
+		resp := makeSimpleRequest(thruster.GET, testServer.URL+"/json")
+
+		data, err := ioutil.ReadFile(logPath)
+		Expect(err).ToNot(HaveOccurred())
+
+		entry := map[string]interface{}{}
+		Expect(json.Unmarshal([]byte(strings.Split(string(data), "\n")[0]), &entry)).To(Succeed())
+		Expect(entry["msg"]).To(Equal("panic recovered"))
+		Expect(entry["panic"]).To(Equal("boom"))
+		Expect(entry["route"]).To(Equal("/json"))
+		Expect(entry["request_id"]).To(Equal(resp.Header.Get("X-Request-ID")))
+		Expect(entry["stack"]).To(ContainSubstring("recovery_test.go"))
+	})
+
+	It("calls the OnPanic callbacks", func() {
+		makeSimpleRequest(thruster.GET, testServer.URL+"/plain")
+
+		Expect(events).To(HaveLen(1))
+		Expect(events[0].Value).To(Equal("boom"))
+		Expect(events[0].Route.Path).To(Equal("/plain"))
+		Expect(events[0].Stack).ToNot(BeEmpty())
+	})
+
+	It("aborts the response on http.ErrAbortHandler", func() {
+		_, err := http.Get(testServer.URL + "/abort")
+		Expect(err).To(HaveOccurred())
+		Expect(events).To(BeEmpty())
+	})
+
+	Context("with the default engine", func() {
+		var url string
+
+		JustBeforeEach(func() {
+			port := rand.Intn(8000) + 3000
+			url = "http://localhost:" + strconv.Itoa(port)
+
+			server := thruster.NewServer(thruster.Config{Hostname: "localhost", Port: port})
+			server.OnPanic(func(event thruster.PanicEvent) {
+				panic("callback boom")
+			})
+			server.AddHandler(thruster.GET, "/plain", func(c *gin.Context) {
+				panic("boom")
+			})
+			server.AddHandler(thruster.GET, "/abort", func(c *gin.Context) {
+				panic(http.ErrAbortHandler)
+			})
+			startServer(server, port)
+		})
+
+		It("recovers the panics out of the handlers with gin's recovery", func() {
+			response, err := http.Get(url + "/plain")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(http.StatusInternalServerError))
+		})
+
+		It("still aborts the response on http.ErrAbortHandler", func() {
+			_, err := http.Get(url + "/abort")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,174 @@
+package thruster
+
+import (
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DefaultConfigPollInterval is how often WatchConfig checks the watched
+// files for changes, unless it's given another interval.
+const DefaultConfigPollInterval = 2 * time.Second
+
+// restartRequiredFields are the config fields used when the listeners are
+// created or the routes registered, which a reload can't change.
+var restartRequiredFields = []string{
+	"Hostname", "Port", "TLS",
+	"Timeouts.Read", "Timeouts.ReadHeader", "Timeouts.Write", "Timeouts.Idle",
+	"Limits.MaxHeaderBytes",
+	"Explorer.Enabled", "Explorer.Path", "Explorer.AllowInRelease",
+	"Metrics.Enabled", "Metrics.Path", "Metrics.Address",
+	"Health.Enabled", "Health.LivenessPath", "Health.ReadinessPath", "Health.RequireAuth",
+	"Admin.Enabled", "Admin.Prefix", "Admin.Address",
+}
+
+// ReloadEvent is sent to the OnReload callbacks after every reload attempt.
+// When Err is set the new config was rejected and Previous is still in use.
+type ReloadEvent struct {
+	Previous Config
+	Config   Config
+	Err      error
+
+	// RestartRequired lists the changed fields that only apply after a
+	// restart, as the listeners and routes are not recreated, e.g.
+	// "Metrics.Address". Their previous values are kept.
+	RestartRequired []string
+}
+
+// OnReload registers a callback to be called after every reload attempt.
+func (s *Server) OnReload(callback func(ReloadEvent)) {
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+	s.reloadCallbacks = append(s.reloadCallbacks, callback)
+}
+
+// Reload validates config and swaps it in atomically. Requests already in
+// progress finish with the previous config. An invalid config is rejected
+// and the previous one is kept.
+func (s *Server) Reload(config Config) error {
+	previous := s.currentConfig()
+	event := ReloadEvent{Previous: previous, Config: config}
+
+	for _, field := range restartRequiredFields {
+		newValue := fieldByPath(reflect.ValueOf(&config).Elem(), field)
+		oldValue := fieldByPath(reflect.ValueOf(previous), field)
+		if !reflect.DeepEqual(newValue.Interface(), oldValue.Interface()) {
+			event.RestartRequired = append(event.RestartRequired, field)
+			newValue.Set(oldValue)
+		}
+	}
+	event.Config = config
+
+	event.Err = s.applyConfig(config)
+	if event.Err != nil {
+		event.Config = previous
+		s.logf(LogLevelWarn, "config rejected: %s", event.Err)
+	} else {
+		s.logf(LogLevelInfo, "config reloaded")
+	}
+
+	s.notifyReload(event)
+	return event.Err
+}
+
+// fieldByPath returns the field of value at a dotted path of field names.
+func fieldByPath(value reflect.Value, path string) reflect.Value {
+	for _, name := range strings.Split(path, ".") {
+		value = value.FieldByName(name)
+	}
+	return value
+}
+
+func (s *Server) applyConfig(config Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	certificates := &certificateCache{}
+	if config.TLS {
+		if _, err := certificates.get(config); err != nil {
+			return err
+		}
+	}
+
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+	s.config = config
+	s.certificates = certificates
+	s.networks = newConfigNetworks(config)
+	return nil
+}
+
+// WatchConfig calls load and reloads the server with its result whenever one
+// of paths changes, checked every interval (DefaultConfigPollInterval when
+// it's 0), or the process receives SIGHUP. Calling the returned function
+// stops watching, once the reload in progress, if any, is over.
+func (s *Server) WatchConfig(load func() (Config, error), interval time.Duration, paths ...string) (stop func()) {
+	if interval <= 0 {
+		interval = DefaultConfigPollInterval
+	}
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	ticker := time.NewTicker(interval)
+	modTimes := fileModTimes(paths)
+
+	go func() {
+		defer close(finished)
+		defer signal.Stop(signals)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-signals:
+			case <-ticker.C:
+				current := fileModTimes(paths)
+				if reflect.DeepEqual(current, modTimes) {
+					continue
+				}
+				modTimes = current
+			}
+
+			config, err := load()
+			if err != nil {
+				previous := s.currentConfig()
+				s.logf(LogLevelWarn, "config rejected: %s", err)
+				s.notifyReload(ReloadEvent{Previous: previous, Config: previous, Err: err})
+				continue
+			}
+			s.Reload(config)
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+func (s *Server) notifyReload(event ReloadEvent) {
+	s.configMutex.RLock()
+	callbacks := s.reloadCallbacks
+	s.configMutex.RUnlock()
+
+	for _, callback := range callbacks {
+		callback(event)
+	}
+}
+
+func fileModTimes(paths []string) map[string]time.Time {
+	modTimes := map[string]time.Time{}
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			modTimes[path] = info.ModTime()
+		}
+	}
+	return modTimes
+}
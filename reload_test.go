@@ -0,0 +1,218 @@
+package thruster_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tscolari/thruster"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Config reload", func() {
+	var subject *thruster.Server
+	var config thruster.Config
+	var testServer *httptest.Server
+	var events chan thruster.ReloadEvent
+
+	getAs := func(username, password string) int {
+		request, err := http.NewRequest(thruster.GET, testServer.URL+"/test", nil)
+		Expect(err).ToNot(HaveOccurred())
+		request.SetBasicAuth(username, password)
+		resp, err := http.DefaultClient.Do(request)
+		Expect(err).ToNot(HaveOccurred())
+		return resp.StatusCode
+	}
+
+	BeforeEach(func() {
+		config = thruster.Config{
+			Hostname: "localhost",
+			Port:     8080,
+			HTTPAuth: []thruster.HTTPAuth{thruster.NewHTTPAuth("admin", "old")},
+		}
+
+		engine := gin.New()
+		subject = thruster.NewServerWithEngine(config, engine)
+		subject.AddHandler(thruster.GET, "/test", func(c *gin.Context) {
+			c.String(200, "OK")
+		})
+		testServer = httptest.NewServer(engine)
+
+		received := make(chan thruster.ReloadEvent, 10)
+		subject.OnReload(func(event thruster.ReloadEvent) {
+			received <- event
+		})
+		events = received
+	})
+
+	AfterEach(func() {
+		testServer.Close()
+	})
+
+	Describe("Reload", func() {
+		It("applies new HTTP auth credentials to the running server", func() {
+			Expect(getAs("admin", "old")).To(Equal(http.StatusOK))
+
+			config.HTTPAuth = []thruster.HTTPAuth{thruster.NewHTTPAuth("admin", "new")}
+			Expect(subject.Reload(config)).To(Succeed())
+
+			Expect(getAs("admin", "old")).To(Equal(http.StatusUnauthorized))
+			Expect(getAs("admin", "new")).To(Equal(http.StatusOK))
+		})
+
+		It("can enable HTTP auth on a server that had none", func() {
+			config.HTTPAuth = nil
+			Expect(subject.Reload(config)).To(Succeed())
+			Expect(getAs("any", "thing")).To(Equal(http.StatusOK))
+
+			config.HTTPAuth = []thruster.HTTPAuth{thruster.NewHTTPAuth("admin", "new")}
+			Expect(subject.Reload(config)).To(Succeed())
+			Expect(getAs("any", "thing")).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("emits a reload event", func() {
+			config.HTTPAuth = []thruster.HTTPAuth{thruster.NewHTTPAuth("admin", "new")}
+			Expect(subject.Reload(config)).To(Succeed())
+
+			var event thruster.ReloadEvent
+			Eventually(events).Should(Receive(&event))
+			Expect(event.Err).ToNot(HaveOccurred())
+			Expect(event.Previous.HTTPAuth[0].Password).To(Equal("old"))
+			Expect(event.Config.HTTPAuth[0].Password).To(Equal("new"))
+		})
+
+		It("rejects invalid configs and keeps the previous one", func() {
+			config.HTTPAuth = []thruster.HTTPAuth{thruster.NewHTTPAuth("", "new")}
+			err := subject.Reload(config)
+			Expect(err).To(HaveOccurred())
+
+			var event thruster.ReloadEvent
+			Eventually(events).Should(Receive(&event))
+			Expect(event.Err).To(Equal(err))
+			Expect(event.Config.HTTPAuth[0].Password).To(Equal("old"))
+
+			Expect(getAs("admin", "old")).To(Equal(http.StatusOK))
+		})
+
+		It("rejects TLS certificates that can't be loaded", func() {
+			config.TLS = true
+			config.Certificate = "fixtures/server.crt"
+			config.PublicKey = "fixtures/server.crt"
+			subject = thruster.NewServerWithEngine(config, gin.New())
+
+			config.HTTPAuth = []thruster.HTTPAuth{thruster.NewHTTPAuth("admin", "new")}
+			Expect(subject.Reload(config)).ToNot(Succeed())
+		})
+
+		It("keeps the listener settings, reporting them as requiring a restart", func() {
+			config.Port = 9090
+			config.Hostname = "example.com"
+			Expect(subject.Reload(config)).To(Succeed())
+
+			var event thruster.ReloadEvent
+			Eventually(events).Should(Receive(&event))
+			Expect(event.RestartRequired).To(Equal([]string{"Hostname", "Port"}))
+			Expect(event.Config.Port).To(Equal(8080))
+			Expect(event.Config.Hostname).To(Equal("localhost"))
+		})
+
+		It("keeps the settings of the other listeners and mounted routes", func() {
+			config.Metrics = thruster.Metrics{Enabled: true, Address: "127.0.0.1:9100"}
+			config.Timeouts.Read = time.Second
+			config.Limits.MaxHeaderBytes = 4096
+			Expect(subject.Reload(config)).To(Succeed())
+
+			var event thruster.ReloadEvent
+			Eventually(events).Should(Receive(&event))
+			Expect(event.RestartRequired).To(Equal([]string{"Timeouts.Read", "Limits.MaxHeaderBytes", "Metrics.Enabled", "Metrics.Address"}))
+			Expect(event.Config.Metrics).To(Equal(thruster.Metrics{}))
+			Expect(event.Config.Timeouts.Read).To(BeZero())
+		})
+	})
+
+	Describe("WatchConfig", func() {
+		var dir, path string
+		var stop func()
+		var loads int
+		var loadsMutex sync.Mutex
+
+		// writeFile replaces the config at once, so the watcher never reads
+		// it half written.
+		writeFile := func(data string) {
+			Expect(ioutil.WriteFile(path+".tmp", []byte(data), 0600)).To(Succeed())
+			Expect(os.Rename(path+".tmp", path)).To(Succeed())
+		}
+
+		writeConfig := func(password string) {
+			writeFile("hostname: localhost\nport: 8080\nhttp_auth:\n- username: admin\n  password: " + password + "\n")
+		}
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "thruster")
+			Expect(err).ToNot(HaveOccurred())
+			path = filepath.Join(dir, "config.yml")
+			writeConfig("old")
+
+			loads = 0
+			stop = subject.WatchConfig(func() (thruster.Config, error) {
+				loadsMutex.Lock()
+				loads++
+				loadsMutex.Unlock()
+				return thruster.NewConfig(path)
+			}, 10*time.Millisecond, path)
+		})
+
+		AfterEach(func() {
+			stop()
+			os.RemoveAll(dir)
+		})
+
+		It("reloads when the file changes", func() {
+			time.Sleep(20 * time.Millisecond)
+			writeConfig("new")
+
+			Eventually(func() int { return getAs("admin", "new") }).Should(Equal(http.StatusOK))
+			Expect(getAs("admin", "old")).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("reloads on SIGHUP", func() {
+			Expect(syscall.Kill(os.Getpid(), syscall.SIGHUP)).To(Succeed())
+
+			Eventually(func() int {
+				loadsMutex.Lock()
+				defer loadsMutex.Unlock()
+				return loads
+			}).Should(Equal(1))
+		})
+
+		It("doesn't load after stop returns", func() {
+			stop()
+			stop = func() {}
+			writeConfig("new")
+			time.Sleep(50 * time.Millisecond)
+
+			loadsMutex.Lock()
+			defer loadsMutex.Unlock()
+			Expect(loads).To(Equal(0))
+		})
+
+		It("keeps the previous config when the file is invalid", func() {
+			time.Sleep(20 * time.Millisecond)
+			writeFile("port: [\n")
+
+			var event thruster.ReloadEvent
+			Eventually(events).Should(Receive(&event))
+			Expect(event.Err).To(HaveOccurred())
+			Expect(getAs("admin", "old")).To(Equal(http.StatusOK))
+		})
+	})
+})
@@ -0,0 +1,68 @@
+package thruster
+
+import (
+	"crypto/rand"
+	"fmt"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	requestIDKey = "thruster.request_id"
+
+	DefaultRequestIDHeader    = "X-Request-ID"
+	DefaultRequestIDMaxLength = 128
+)
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:+/=-]+$`)
+
+// RequestID returns the ID of the request, taken from the request header or
+// generated by the server.
+func RequestID(c *gin.Context) string {
+	if id, ok := c.Get(requestIDKey); ok {
+		return id.(string)
+	}
+	return ""
+}
+
+func (r RequestIDConfig) header() string {
+	if r.Header == "" {
+		return DefaultRequestIDHeader
+	}
+	return r.Header
+}
+
+func (r RequestIDConfig) maxLength() int {
+	if r.MaxLength <= 0 {
+		return DefaultRequestIDMaxLength
+	}
+	return r.MaxLength
+}
+
+// assignRequestID takes the request ID from the incoming header, when it's
+// valid, or generates a new one, and echoes it in the response.
+func (s *Server) assignRequestID(c *gin.Context) {
+	config := s.currentConfig().RequestID
+	header := config.header()
+
+	id := c.Request.Header.Get(header)
+	if len(id) > config.maxLength() || !requestIDPattern.MatchString(id) {
+		id = newRequestID()
+	}
+
+	c.Set(requestIDKey, id)
+	c.Header(header, id)
+}
+
+// newRequestID returns a random (version 4) UUID.
+func newRequestID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+
+	id[6] = (id[6] & 0x0f) | 0x40
+	id[8] = (id[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+}
@@ -0,0 +1,105 @@
+package thruster_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tscolari/thruster"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Request ID", func() {
+	var subject *thruster.Server
+	var config thruster.Config
+	var testServer *httptest.Server
+	var handlerRequestID string
+
+	get := func(path string, headers map[string]string) *http.Response {
+		request, err := http.NewRequest(thruster.GET, testServer.URL+path, nil)
+		Expect(err).ToNot(HaveOccurred())
+		for key, value := range headers {
+			request.Header.Set(key, value)
+		}
+		resp, err := http.DefaultClient.Do(request)
+		Expect(err).ToNot(HaveOccurred())
+		return resp
+	}
+
+	BeforeEach(func() {
+		config = thruster.Config{}
+		handlerRequestID = ""
+	})
+
+	JustBeforeEach(func() {
+		engine := gin.New()
+		subject = thruster.NewServerWithEngine(config, engine)
+		subject.AddHandler(thruster.GET, "/test", func(c *gin.Context) {
+			handlerRequestID = thruster.RequestID(c)
+			c.String(200, "OK")
+		})
+		subject.AddJSONHandler(thruster.GET, "/fail", func(c *gin.Context) (interface{}, error) {
+			return nil, errors.New("failed")
+		})
+		testServer = httptest.NewServer(engine)
+	})
+
+	AfterEach(func() {
+		testServer.Close()
+	})
+
+	It("generates a request ID and echoes it in the response", func() {
+		resp := get("/test", nil)
+		Expect(handlerRequestID).To(MatchRegexp(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`))
+		Expect(resp.Header.Get("X-Request-ID")).To(Equal(handlerRequestID))
+	})
+
+	It("generates a different ID for each request", func() {
+		first := get("/test", nil).Header.Get("X-Request-ID")
+		second := get("/test", nil).Header.Get("X-Request-ID")
+		Expect(first).ToNot(Equal(second))
+	})
+
+	It("uses the incoming request ID", func() {
+		resp := get("/test", map[string]string{"X-Request-ID": "abc-123"})
+		Expect(handlerRequestID).To(Equal("abc-123"))
+		Expect(resp.Header.Get("X-Request-ID")).To(Equal("abc-123"))
+	})
+
+	It("replaces invalid incoming request IDs", func() {
+		get("/test", map[string]string{"X-Request-ID": "<script>"})
+		Expect(handlerRequestID).ToNot(Equal("<script>"))
+
+		get("/test", map[string]string{"X-Request-ID": strings.Repeat("a", 129)})
+		Expect(handlerRequestID).To(HaveLen(36))
+	})
+
+	It("includes it in the JSON error bodies", func() {
+		resp := get("/fail", map[string]string{"X-Request-ID": "abc-123"})
+
+		var body map[string]string
+		Expect(json.NewDecoder(resp.Body).Decode(&body)).To(Succeed())
+		Expect(body).To(Equal(map[string]string{"error": "failed", "request_id": "abc-123"}))
+	})
+
+	Context("with a custom header and length", func() {
+		BeforeEach(func() {
+			config.RequestID = thruster.RequestIDConfig{Header: "X-Correlation-ID", MaxLength: 5}
+		})
+
+		It("uses them", func() {
+			resp := get("/test", map[string]string{"X-Correlation-ID": "abcde"})
+			Expect(handlerRequestID).To(Equal("abcde"))
+			Expect(resp.Header.Get("X-Correlation-ID")).To(Equal("abcde"))
+			Expect(resp.Header.Get("X-Request-ID")).To(BeEmpty())
+
+			get("/test", map[string]string{"X-Correlation-ID": "abcdef"})
+			Expect(handlerRequestID).ToNot(Equal("abcdef"))
+		})
+	})
+})
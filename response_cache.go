@@ -0,0 +1,420 @@
+package thruster
+
+import (
+	"bufio"
+	"container/list"
+	"context"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultCacheMaxEntries = 1000
+
+// CacheStore keeps the cached responses. The default one is an in-memory
+// LRU; a shared one caches across instances.
+type CacheStore interface {
+	// Get returns the response stored at key, or nil.
+	Get(key string) (*CachedResponse, error)
+	// Set stores response at key for ttl.
+	Set(key string, response *CachedResponse, ttl time.Duration) error
+	// DeletePrefix drops the responses whose key starts with prefix.
+	DeletePrefix(prefix string) error
+}
+
+// CachedResponse is the JSON body of a GET response, with the headers and
+// the ETag the handler set, but for its cookies and hop-by-hop headers.
+type CachedResponse struct {
+	Body   []byte
+	Header http.Header
+	ETag   string
+	Stored time.Time
+}
+
+// uncachedHeaders are kept out of the cached responses, as the cookies are
+// the client's own and the hop-by-hop headers the connection's.
+var uncachedHeaders = []string{
+	"Set-Cookie", "Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Proxy-Connection", "TE", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// cacheFlight is a handler call filling a cache entry, which the
+// concurrent misses of the entry wait for.
+type cacheFlight struct {
+	done     chan struct{}
+	response *CachedResponse
+	err      error
+}
+
+// SetCacheStore keeps the cached responses in store, instead of in memory.
+func (s *Server) SetCacheStore(store CacheStore) {
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+	s.cacheStore = store
+}
+
+func (s *Server) responseCache() CacheStore {
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+	if s.cacheStore == nil {
+		s.cacheStore = NewMemoryCacheStore(s.config.ResponseCache.MaxEntries)
+	}
+	return s.cacheStore
+}
+
+// rule returns the rule of route, if it's cached.
+func (r ResponseCache) rule(route Route) (CacheRule, bool) {
+	rule, found := r.Routes[route.Method+" "+route.Path]
+	return rule, r.Enabled && found && rule.TTL > 0
+}
+
+// cacheControl is the Cache-Control header of the responses of rule,
+// private when they depend on the user.
+func (r CacheRule) cacheControl(authenticated bool) string {
+	value := "public"
+	if r.VaryByUser || authenticated {
+		value = "private"
+	}
+	value += ", max-age=" + strconv.Itoa(ceilSeconds(r.TTL))
+	if r.StaleWhileRevalidate > 0 {
+		value += ", stale-while-revalidate=" + strconv.Itoa(ceilSeconds(r.StaleWhileRevalidate))
+	}
+	return value
+}
+
+// cacheResource is the prefix of the cache keys of route, shared by the
+// routes of a resource, so its changes invalidate them all.
+func cacheResource(route Route) string {
+	if route.resource != "" {
+		return route.resource + "|"
+	}
+	return route.Path + "|"
+}
+
+// cacheKey identifies the response of the request, by its path, sorted
+// query, the rule's headers and, when set, the user.
+func cacheKey(c *gin.Context, route Route, rule CacheRule) string {
+	key := cacheResource(route) + c.Request.Method + " " + c.Request.URL.Path
+	if query := c.Request.URL.Query(); len(query) > 0 {
+		key += "?" + query.Encode()
+	}
+	for _, header := range rule.VaryHeaders {
+		key += "|" + http.CanonicalHeaderKey(header) + "=" + c.Request.Header.Get(header)
+	}
+	if rule.VaryByUser {
+		key += "|user=" + AuthenticatedUser(c)
+	}
+	return key
+}
+
+// serveCached responds from the cache on the cached routes, filling it on
+// misses and revalidating it in the background when stale. It reports if
+// the route is cached.
+func (s *Server) serveCached(c *gin.Context, route Route, handler JSONHandler) bool {
+	config := s.currentConfig()
+	rule, cached := config.ResponseCache.rule(route)
+	if !cached {
+		return false
+	}
+
+	for _, header := range rule.VaryHeaders {
+		addVary(c.Writer.Header(), http.CanonicalHeaderKey(header))
+	}
+
+	key := cacheKey(c, route, rule)
+	response, err := s.responseCache().Get(key)
+	if err != nil {
+		// Serves from the handler rather than failing on a store outage.
+		s.logf(LogLevelWarn, "response cache: %s", err)
+		response = nil
+	}
+
+	age := time.Duration(0)
+	if response != nil {
+		age = time.Since(response.Stored)
+	}
+
+	switch {
+	case response != nil && age < rule.TTL:
+		c.Header("X-Cache", "HIT")
+	case response != nil && age < rule.TTL+rule.StaleWhileRevalidate:
+		c.Header("X-Cache", "STALE")
+		s.revalidate(c, key, route, rule, handler)
+	default:
+		c.Header("X-Cache", "MISS")
+		response, err = s.fillCache(c, key, route, rule, handler)
+		if err != nil {
+			c.JSON(s.statusError(err), jsonError(c, err))
+			return true
+		}
+	}
+
+	for name, values := range response.Header {
+		c.Writer.Header()[name] = append([]string(nil), values...)
+	}
+	c.Header("Cache-Control", rule.cacheControl(len(config.HTTPAuth) > 0 && !route.public))
+	if response.ETag != "" {
+		SetETag(c, response.ETag)
+	}
+	s.writeJSON(c, http.StatusOK, response.Body)
+	return true
+}
+
+// startFlight returns the flight filling key, and if it was started by
+// this call, in which case the caller must land it.
+func (s *Server) startFlight(key string) (*cacheFlight, bool) {
+	s.cacheMutex.Lock()
+	defer s.cacheMutex.Unlock()
+
+	if flight, found := s.cacheFlights[key]; found {
+		return flight, false
+	}
+	if s.cacheFlights == nil {
+		s.cacheFlights = map[string]*cacheFlight{}
+	}
+	// The error stays if the handler panics.
+	flight := &cacheFlight{done: make(chan struct{}), err: ErrInternal}
+	s.cacheFlights[key] = flight
+	return flight, true
+}
+
+func (s *Server) landFlight(key string, flight *cacheFlight) {
+	s.cacheMutex.Lock()
+	delete(s.cacheFlights, key)
+	s.cacheMutex.Unlock()
+	close(flight.done)
+}
+
+// fillCache calls handler once for the concurrent misses of key.
+func (s *Server) fillCache(c *gin.Context, key string, route Route, rule CacheRule, handler JSONHandler) (*CachedResponse, error) {
+	flight, started := s.startFlight(key)
+	if !started {
+		select {
+		case <-flight.done:
+			return flight.response, flight.err
+		case <-c.Request.Context().Done():
+			return nil, ErrTimeout
+		}
+	}
+
+	defer s.landFlight(key, flight)
+	flight.response, flight.err = s.storeResponse(c, key, route, rule, handler)
+	return flight.response, flight.err
+}
+
+// revalidate refreshes the stale entry at key in the background, unless
+// it's already being filled. The handler gets a copy of the context,
+// which outlives the request, writing to a backgroundWriter.
+func (s *Server) revalidate(c *gin.Context, key string, route Route, rule CacheRule, handler JSONHandler) {
+	flight, started := s.startFlight(key)
+	if !started {
+		return
+	}
+
+	background := c.Copy()
+	writer := &backgroundWriter{header: http.Header{}, status: http.StatusOK}
+	for name, values := range c.Writer.Header() {
+		writer.header[name] = append([]string(nil), values...)
+	}
+	background.Writer = writer
+	background.Request = c.Request.WithContext(context.WithoutCancel(c.Request.Context()))
+	background.Params = append(gin.Params(nil), c.Params...)
+	background.Keys = make(map[string]interface{}, len(c.Keys))
+	for name, value := range c.Keys {
+		background.Keys[name] = value
+	}
+	background.Keys[etagKey] = ""
+
+	go func() {
+		defer s.landFlight(key, flight)
+		defer func() {
+			if value := recover(); value != nil {
+				route, _ := CurrentRoute(background)
+				s.logPanic(PanicEvent{Context: background, Route: route, Value: value, Stack: debug.Stack()})
+				s.serverMetrics.panics.Inc(route.Path)
+			}
+		}()
+
+		flight.response, flight.err = s.storeResponse(background, key, route, rule, handler)
+	}()
+}
+
+// storeResponse calls handler and caches its response, unless it failed
+// or the resource was invalidated meanwhile.
+func (s *Server) storeResponse(c *gin.Context, key string, route Route, rule CacheRule, handler JSONHandler) (*CachedResponse, error) {
+	resource := cacheResource(route)
+	generation := s.cacheGeneration(resource)
+
+	header := c.Writer.Header()
+	before := make(http.Header, len(header))
+	for name, values := range header {
+		before[name] = values
+	}
+
+	data, err := handler(c)
+	if err != nil {
+		return nil, err
+	}
+	body, err := encodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &CachedResponse{Body: body, Header: handlerHeader(before, header), Stored: time.Now()}
+	for _, name := range uncachedHeaders {
+		response.Header.Del(name)
+	}
+	if etag, found := c.Get(etagKey); found {
+		response.ETag = etag.(string)
+	}
+
+	// The read lock keeps the invalidations from passing the check before
+	// the response is stored.
+	s.cacheGenerationMutex.RLock()
+	defer s.cacheGenerationMutex.RUnlock()
+	if s.cacheGenerations[resource] != generation {
+		return response, nil
+	}
+	if err := s.responseCache().Set(key, response, rule.TTL+rule.StaleWhileRevalidate); err != nil {
+		s.logf(LogLevelWarn, "response cache: %s", err)
+	}
+	return response, nil
+}
+
+func (s *Server) cacheGeneration(resource string) uint64 {
+	s.cacheGenerationMutex.RLock()
+	defer s.cacheGenerationMutex.RUnlock()
+	return s.cacheGenerations[resource]
+}
+
+// invalidateCache drops the cached responses of the resource of route,
+// after one of its changes succeeded, and those being computed.
+func (s *Server) invalidateCache(route Route) {
+	if !s.currentConfig().ResponseCache.Enabled {
+		return
+	}
+
+	resource := cacheResource(route)
+	s.cacheGenerationMutex.Lock()
+	if s.cacheGenerations == nil {
+		s.cacheGenerations = map[string]uint64{}
+	}
+	s.cacheGenerations[resource]++
+	s.cacheGenerationMutex.Unlock()
+
+	if err := s.responseCache().DeletePrefix(resource); err != nil {
+		s.logf(LogLevelWarn, "response cache: %s", err)
+	}
+}
+
+// backgroundWriter is the writer of the handlers revalidating the cache
+// after their request is over. It keeps their headers and drops the rest.
+type backgroundWriter struct {
+	header http.Header
+	status int
+}
+
+func (w *backgroundWriter) Header() http.Header                  { return w.header }
+func (w *backgroundWriter) Write(data []byte) (int, error)       { return len(data), nil }
+func (w *backgroundWriter) WriteString(data string) (int, error) { return len(data), nil }
+func (w *backgroundWriter) WriteHeader(code int)                 { w.status = code }
+func (w *backgroundWriter) WriteHeaderNow()                      {}
+func (w *backgroundWriter) Status() int                          { return w.status }
+func (w *backgroundWriter) Size() int                            { return -1 }
+func (w *backgroundWriter) Written() bool                        { return false }
+func (w *backgroundWriter) Flush()                               {}
+func (w *backgroundWriter) CloseNotify() <-chan bool             { return make(chan bool) }
+
+func (w *backgroundWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, http.ErrNotSupported
+}
+
+// MemoryCacheStore keeps up to maxEntries responses in memory, evicting
+// the least recently used ones.
+type MemoryCacheStore struct {
+	mutex      sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	// recent lists the entries, the most recently used first.
+	recent *list.List
+	now    func() time.Time
+}
+
+type memoryCacheEntry struct {
+	key      string
+	response *CachedResponse
+	expires  time.Time
+}
+
+// NewMemoryCacheStore returns a store of up to maxEntries responses, 1000
+// when it's 0.
+func NewMemoryCacheStore(maxEntries int) *MemoryCacheStore {
+	if maxEntries <= 0 {
+		maxEntries = defaultCacheMaxEntries
+	}
+	return &MemoryCacheStore{
+		maxEntries: maxEntries,
+		entries:    map[string]*list.Element{},
+		recent:     list.New(),
+		now:        time.Now,
+	}
+}
+
+func (m *MemoryCacheStore) Get(key string) (*CachedResponse, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	element, found := m.entries[key]
+	if !found {
+		return nil, nil
+	}
+
+	entry := element.Value.(*memoryCacheEntry)
+	if !m.now().Before(entry.expires) {
+		m.remove(element)
+		return nil, nil
+	}
+	m.recent.MoveToFront(element)
+	return entry.response, nil
+}
+
+func (m *MemoryCacheStore) Set(key string, response *CachedResponse, ttl time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	entry := &memoryCacheEntry{key: key, response: response, expires: m.now().Add(ttl)}
+	if element, found := m.entries[key]; found {
+		element.Value = entry
+		m.recent.MoveToFront(element)
+		return nil
+	}
+
+	m.entries[key] = m.recent.PushFront(entry)
+	for m.recent.Len() > m.maxEntries {
+		m.remove(m.recent.Back())
+	}
+	return nil
+}
+
+func (m *MemoryCacheStore) DeletePrefix(prefix string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for key, element := range m.entries {
+		if strings.HasPrefix(key, prefix) {
+			m.remove(element)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryCacheStore) remove(element *list.Element) {
+	delete(m.entries, element.Value.(*memoryCacheEntry).key)
+	m.recent.Remove(element)
+}
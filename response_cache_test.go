@@ -0,0 +1,297 @@
+package thruster_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tscolari/thruster"
+	"github.com/tscolari/thruster/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ResponseCache", func() {
+	var config thruster.Config
+	var controller *fakes.FakeJSONController
+	var calls int32
+	var testServer *httptest.Server
+
+	request := func(method, path string, headers map[string]string) (*http.Response, string) {
+		request, err := http.NewRequest(method, testServer.URL+path, nil)
+		Expect(err).ToNot(HaveOccurred())
+		for key, value := range headers {
+			request.Header.Set(key, value)
+		}
+		resp, err := http.DefaultClient.Do(request)
+		Expect(err).ToNot(HaveOccurred())
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp, strings.TrimSpace(string(body))
+	}
+
+	BeforeEach(func() {
+		atomic.StoreInt32(&calls, 0)
+		controller = &fakes.FakeJSONController{}
+		controller.ShowStub = func(c *gin.Context) (interface{}, error) {
+			call := strconv.Itoa(int(atomic.AddInt32(&calls, 1)))
+			c.Header("X-Call", call)
+			return c.Param("id") + ":" + call, nil
+		}
+
+		config = thruster.Config{ResponseCache: thruster.ResponseCache{
+			Enabled: true,
+			Routes: map[string]thruster.CacheRule{
+				"GET /users/:id": {TTL: time.Minute, VaryHeaders: []string{"Accept-Language"}},
+			},
+		}}
+	})
+
+	JustBeforeEach(func() {
+		engine := gin.New()
+		subject := thruster.NewServerWithEngine(config, engine)
+		subject.AddJSONResource("/users", controller)
+		testServer = httptest.NewServer(engine)
+	})
+
+	AfterEach(func() {
+		testServer.Close()
+	})
+
+	It("serves the responses from the cache for their TTL", func() {
+		resp, body := request(thruster.GET, "/users/1", nil)
+		Expect(resp.Header.Get("X-Cache")).To(Equal("MISS"))
+		Expect(resp.Header.Get("Cache-Control")).To(Equal("public, max-age=60"))
+		Expect(resp.Header.Get("Vary")).To(Equal("Accept-Language"))
+		Expect(body).To(Equal(`"1:1"`))
+
+		resp, body = request(thruster.GET, "/users/1", nil)
+		Expect(resp.Header.Get("X-Cache")).To(Equal("HIT"))
+		Expect(body).To(Equal(`"1:1"`))
+		Expect(controller.ShowCallCount()).To(Equal(1))
+	})
+
+	It("caches the headers the handler sets", func() {
+		resp, _ := request(thruster.GET, "/users/1", nil)
+		Expect(resp.Header.Get("X-Call")).To(Equal("1"))
+
+		resp, _ = request(thruster.GET, "/users/1", nil)
+		Expect(resp.Header.Get("X-Cache")).To(Equal("HIT"))
+		Expect(resp.Header.Get("X-Call")).To(Equal("1"))
+	})
+
+	It("doesn't cache the cookies nor the hop-by-hop headers", func() {
+		controller.ShowStub = func(c *gin.Context) (interface{}, error) {
+			call := strconv.Itoa(int(atomic.AddInt32(&calls, 1)))
+			c.Header("Set-Cookie", "session="+call)
+			c.Header("Connection", "close")
+			c.Header("X-Call", call)
+			return call, nil
+		}
+
+		resp, _ := request(thruster.GET, "/users/1", nil)
+		Expect(resp.Header.Get("Set-Cookie")).To(Equal("session=1"))
+
+		resp, _ = request(thruster.GET, "/users/1", nil)
+		Expect(resp.Header.Get("X-Cache")).To(Equal("HIT"))
+		Expect(resp.Header.Get("X-Call")).To(Equal("1"))
+		Expect(resp.Header.Get("Set-Cookie")).To(BeEmpty())
+		Expect(resp.Header.Get("Connection")).To(BeEmpty())
+	})
+
+	It("keys the responses by path, query and the vary headers", func() {
+		request(thruster.GET, "/users/1", nil)
+
+		_, body := request(thruster.GET, "/users/2", nil)
+		Expect(body).To(Equal(`"2:2"`))
+		_, body = request(thruster.GET, "/users/1?b=2&a=1", nil)
+		Expect(body).To(Equal(`"1:3"`))
+		_, body = request(thruster.GET, "/users/1?a=1&b=2", nil)
+		Expect(body).To(Equal(`"1:3"`))
+		_, body = request(thruster.GET, "/users/1", map[string]string{"Accept-Language": "pt"})
+		Expect(body).To(Equal(`"1:4"`))
+	})
+
+	It("invalidates the resource when it changes", func() {
+		request(thruster.GET, "/users/1", nil)
+		request(thruster.PUT, "/users/1", nil)
+
+		resp, body := request(thruster.GET, "/users/1", nil)
+		Expect(resp.Header.Get("X-Cache")).To(Equal("MISS"))
+		Expect(body).To(Equal(`"1:2"`))
+	})
+
+	It("doesn't store the responses computed before an invalidation", func() {
+		started := make(chan struct{})
+		release := make(chan struct{})
+		controller.ShowStub = func(c *gin.Context) (interface{}, error) {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				close(started)
+				<-release
+				return "before", nil
+			}
+			return "after", nil
+		}
+
+		done := make(chan string)
+		go func() {
+			defer GinkgoRecover()
+			_, body := request(thruster.GET, "/users/1", nil)
+			done <- body
+		}()
+
+		<-started
+		request(thruster.PUT, "/users/1", nil)
+		close(release)
+		Expect(<-done).To(Equal(`"before"`))
+
+		resp, body := request(thruster.GET, "/users/1", nil)
+		Expect(resp.Header.Get("X-Cache")).To(Equal("MISS"))
+		Expect(body).To(Equal(`"after"`))
+	})
+
+	It("doesn't cache failed responses", func() {
+		controller.ShowReturns(nil, thruster.ErrNotFound)
+		controller.ShowStub = nil
+
+		resp, _ := request(thruster.GET, "/users/1", nil)
+		Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+		resp, _ = request(thruster.GET, "/users/1", nil)
+		Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+		Expect(controller.ShowCallCount()).To(Equal(2))
+	})
+
+	It("calls the handler once for concurrent misses", func() {
+		release := make(chan struct{})
+		controller.ShowStub = func(c *gin.Context) (interface{}, error) {
+			atomic.AddInt32(&calls, 1)
+			<-release
+			return "user", nil
+		}
+
+		var wg sync.WaitGroup
+		bodies := make([]string, 5)
+		for i := range bodies {
+			wg.Add(1)
+			go func(i int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				_, bodies[i] = request(thruster.GET, "/users/1", nil)
+			}(i)
+		}
+
+		Eventually(func() int32 { return atomic.LoadInt32(&calls) }).Should(Equal(int32(1)))
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		Expect(controller.ShowCallCount()).To(Equal(1))
+		for _, body := range bodies {
+			Expect(body).To(Equal(`"user"`))
+		}
+	})
+
+	Context("with stale-while-revalidate", func() {
+		BeforeEach(func() {
+			config.ResponseCache.Routes["GET /users/:id"] = thruster.CacheRule{
+				TTL:                  50 * time.Millisecond,
+				StaleWhileRevalidate: time.Minute,
+			}
+		})
+
+		It("serves the stale response while refreshing it in the background", func() {
+			request(thruster.GET, "/users/1", nil)
+			time.Sleep(100 * time.Millisecond)
+
+			resp, body := request(thruster.GET, "/users/1", nil)
+			Expect(resp.Header.Get("X-Cache")).To(Equal("STALE"))
+			Expect(resp.Header.Get("Cache-Control")).To(Equal("public, max-age=1, stale-while-revalidate=60"))
+			Expect(body).To(Equal(`"1:1"`))
+
+			Eventually(func() string {
+				_, body := request(thruster.GET, "/users/1", nil)
+				return body
+			}).Should(Equal(`"1:2"`))
+
+			resp, _ = request(thruster.GET, "/users/1", nil)
+			Expect(resp.Header.Get("X-Call")).To(Equal("2"))
+		})
+	})
+
+	Context("when the responses depend on the user", func() {
+		BeforeEach(func() {
+			config.HTTPAuth = []thruster.HTTPAuth{
+				thruster.NewHTTPAuth("alice", "secret"),
+				thruster.NewHTTPAuth("bob", "secret"),
+			}
+			config.ResponseCache.Routes["GET /users/:id"] = thruster.CacheRule{TTL: time.Minute, VaryByUser: true}
+		})
+
+		It("caches them privately per user", func() {
+			get := func(user string) (*http.Response, string) {
+				request, err := http.NewRequest(thruster.GET, testServer.URL+"/users/1", nil)
+				Expect(err).ToNot(HaveOccurred())
+				request.SetBasicAuth(user, "secret")
+				resp, err := http.DefaultClient.Do(request)
+				Expect(err).ToNot(HaveOccurred())
+				body, _ := ioutil.ReadAll(resp.Body)
+				return resp, strings.TrimSpace(string(body))
+			}
+
+			resp, body := get("alice")
+			Expect(resp.Header.Get("Cache-Control")).To(HavePrefix("private"))
+			Expect(body).To(Equal(`"1:1"`))
+
+			_, body = get("bob")
+			Expect(body).To(Equal(`"1:2"`))
+			_, body = get("alice")
+			Expect(body).To(Equal(`"1:1"`))
+		})
+	})
+})
+
+var _ = Describe("MemoryCacheStore", func() {
+	It("evicts the least recently used responses", func() {
+		store := thruster.NewMemoryCacheStore(2)
+		for _, key := range []string{"a", "b"} {
+			Expect(store.Set(key, &thruster.CachedResponse{Body: []byte(key)}, time.Minute)).To(Succeed())
+		}
+
+		response, _ := store.Get("a")
+		Expect(response).ToNot(BeNil())
+		Expect(store.Set("c", &thruster.CachedResponse{Body: []byte("c")}, time.Minute)).To(Succeed())
+
+		response, _ = store.Get("b")
+		Expect(response).To(BeNil())
+		response, _ = store.Get("a")
+		Expect(response).ToNot(BeNil())
+	})
+
+	It("drops the expired responses", func() {
+		store := thruster.NewMemoryCacheStore(0)
+		Expect(store.Set("a", &thruster.CachedResponse{}, time.Millisecond)).To(Succeed())
+		time.Sleep(5 * time.Millisecond)
+
+		response, _ := store.Get("a")
+		Expect(response).To(BeNil())
+	})
+
+	It("deletes the responses by prefix", func() {
+		store := thruster.NewMemoryCacheStore(0)
+		store.Set("/users|1", &thruster.CachedResponse{}, time.Minute)
+		store.Set("/users_archive|1", &thruster.CachedResponse{}, time.Minute)
+
+		Expect(store.DeletePrefix("/users|")).To(Succeed())
+		response, _ := store.Get("/users|1")
+		Expect(response).To(BeNil())
+		response, _ = store.Get("/users_archive|1")
+		Expect(response).ToNot(BeNil())
+	})
+})
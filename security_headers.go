@@ -0,0 +1,86 @@
+package thruster
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultHSTSMaxAge            = 365 * 24 * time.Hour
+	defaultContentTypeOptions    = "nosniff"
+	defaultFrameOptions          = "DENY"
+	defaultReferrerPolicy        = "strict-origin-when-cross-origin"
+	defaultContentSecurityPolicy = "default-src 'none'; frame-ancestors 'none'"
+
+	// explorerContentSecurityPolicy allows the inline script and style of
+	// the explorer page, and its requests to the server.
+	explorerContentSecurityPolicy = "default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline'; frame-ancestors 'none'"
+
+	// omitHeader disables a security header.
+	omitHeader = "-"
+)
+
+func headerOrDefault(value, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+func (h HSTS) value() string {
+	maxAge := h.MaxAge
+	if maxAge <= 0 {
+		maxAge = defaultHSTSMaxAge
+	}
+
+	value := "max-age=" + strconv.Itoa(int(maxAge.Seconds()))
+	if h.IncludeSubdomains {
+		value += "; includeSubDomains"
+	}
+	if h.Preload {
+		value += "; preload"
+	}
+	return value
+}
+
+// contentSecurityPolicy returns the CSP of route, overridden per path in
+// Routes, or by the built-in pages.
+func (h SecurityHeaders) contentSecurityPolicy(route Route) string {
+	if policy, found := h.Routes[route.Path]; found {
+		return policy
+	}
+	if route.contentSecurityPolicy != "" && h.ContentSecurityPolicy == "" {
+		return route.contentSecurityPolicy
+	}
+	return headerOrDefault(h.ContentSecurityPolicy, defaultContentSecurityPolicy)
+}
+
+// secureHeaders sets the security headers, before the handler, which can
+// still change them. HSTS is only sent over TLS, terminated here or by a
+// trusted proxy, as browsers ignore it on plain HTTP.
+func (s *Server) secureHeaders(c *gin.Context) {
+	config := s.currentConfig().SecurityHeaders
+	if !config.Enabled {
+		return
+	}
+
+	route, _ := CurrentRoute(c)
+	headers := [][2]string{
+		{"X-Content-Type-Options", headerOrDefault(config.ContentTypeOptions, defaultContentTypeOptions)},
+		{"X-Frame-Options", headerOrDefault(config.FrameOptions, defaultFrameOptions)},
+		{"Referrer-Policy", headerOrDefault(config.ReferrerPolicy, defaultReferrerPolicy)},
+		{"Content-Security-Policy", config.contentSecurityPolicy(route)},
+	}
+	if Scheme(c) == "https" && !config.HSTS.Disabled {
+		headers = append(headers, [2]string{"Strict-Transport-Security", config.HSTS.value()})
+	}
+
+	for _, header := range headers {
+		if value := strings.TrimSpace(header[1]); value != omitHeader {
+			c.Header(header[0], value)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package thruster_test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tscolari/thruster"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Security headers", func() {
+	var config thruster.Config
+	var engine *gin.Engine
+	var testServer *httptest.Server
+
+	BeforeEach(func() {
+		config = thruster.Config{
+			Explorer:        thruster.Explorer{Enabled: true},
+			SecurityHeaders: thruster.SecurityHeaders{Enabled: true},
+		}
+	})
+
+	JustBeforeEach(func() {
+		engine = gin.New()
+		subject := thruster.NewServerWithEngine(config, engine)
+		subject.AddHandler(thruster.GET, "/test", func(c *gin.Context) {
+			c.String(200, "OK")
+		})
+		subject.AddHandler(thruster.GET, "/page", func(c *gin.Context) {
+			c.String(200, "OK")
+		})
+		testServer = httptest.NewServer(engine)
+	})
+
+	AfterEach(func() {
+		testServer.Close()
+	})
+
+	It("sets safe defaults", func() {
+		resp := makeSimpleRequest(thruster.GET, testServer.URL+"/test")
+		Expect(resp.Header.Get("X-Content-Type-Options")).To(Equal("nosniff"))
+		Expect(resp.Header.Get("X-Frame-Options")).To(Equal("DENY"))
+		Expect(resp.Header.Get("Referrer-Policy")).To(Equal("strict-origin-when-cross-origin"))
+		Expect(resp.Header.Get("Content-Security-Policy")).To(Equal("default-src 'none'; frame-ancestors 'none'"))
+	})
+
+	It("doesn't send HSTS over plain HTTP", func() {
+		resp := makeSimpleRequest(thruster.GET, testServer.URL+"/test")
+		Expect(resp.Header.Get("Strict-Transport-Security")).To(BeEmpty())
+	})
+
+	It("allows the explorer page scripts", func() {
+		resp := makeSimpleRequest(thruster.GET, testServer.URL+"/explorer")
+		Expect(resp.Header.Get("Content-Security-Policy")).To(ContainSubstring("script-src 'self' 'unsafe-inline'"))
+	})
+
+	Context("over TLS", func() {
+		var tlsServer *httptest.Server
+
+		get := func() *http.Response {
+			client := &http.Client{Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			}}
+			resp, err := client.Get(tlsServer.URL + "/test")
+			Expect(err).ToNot(HaveOccurred())
+			return resp
+		}
+
+		JustBeforeEach(func() {
+			tlsServer = httptest.NewTLSServer(engine)
+		})
+
+		AfterEach(func() {
+			tlsServer.Close()
+		})
+
+		It("sends HSTS", func() {
+			Expect(get().Header.Get("Strict-Transport-Security")).To(Equal("max-age=31536000"))
+		})
+
+		Context("with custom HSTS settings", func() {
+			BeforeEach(func() {
+				config.SecurityHeaders.HSTS = thruster.HSTS{MaxAge: time.Hour, IncludeSubdomains: true, Preload: true}
+			})
+
+			It("uses them", func() {
+				Expect(get().Header.Get("Strict-Transport-Security")).To(Equal("max-age=3600; includeSubDomains; preload"))
+			})
+		})
+
+		Context("with HSTS disabled", func() {
+			BeforeEach(func() {
+				config.SecurityHeaders.HSTS.Disabled = true
+			})
+
+			It("doesn't send it", func() {
+				Expect(get().Header.Get("Strict-Transport-Security")).To(BeEmpty())
+			})
+		})
+	})
+
+	Context("with custom headers", func() {
+		BeforeEach(func() {
+			config.SecurityHeaders.FrameOptions = "SAMEORIGIN"
+			config.SecurityHeaders.ReferrerPolicy = "-"
+			config.SecurityHeaders.Routes = map[string]string{"/page": "default-src 'self'"}
+		})
+
+		It("uses, omits and overrides them", func() {
+			resp := makeSimpleRequest(thruster.GET, testServer.URL+"/test")
+			Expect(resp.Header.Get("X-Frame-Options")).To(Equal("SAMEORIGIN"))
+			Expect(resp.Header).ToNot(HaveKey("Referrer-Policy"))
+			Expect(resp.Header.Get("Content-Security-Policy")).To(Equal("default-src 'none'; frame-ancestors 'none'"))
+
+			resp = makeSimpleRequest(thruster.GET, testServer.URL+"/page")
+			Expect(resp.Header.Get("Content-Security-Policy")).To(Equal("default-src 'self'"))
+		})
+	})
+
+	Context("when disabled", func() {
+		BeforeEach(func() {
+			config.SecurityHeaders.Enabled = false
+		})
+
+		It("doesn't set them", func() {
+			resp := makeSimpleRequest(thruster.GET, testServer.URL+"/test")
+			Expect(resp.Header).ToNot(HaveKey("X-Frame-Options"))
+		})
+	})
+})
@@ -1,34 +1,105 @@
 package thruster
 
 import (
-	"io/ioutil"
+	"crypto/tls"
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/gin-gonic/gin"
 )
 
 type JSONHandler func(*gin.Context) (interface{}, error)
 
+// NewServer returns a server on a new gin engine. The engine has gin's
+// default logger, while the thruster access log isn't enabled, including
+// through a reload. Panics are recovered by thruster on each route, see
+// OnPanic, and by gin's recovery out of them.
 func NewServer(config Config) *Server {
-	return &Server{
-		config: config,
-		engine: gin.Default(),
-	}
+	engine := gin.New()
+	server := NewServerWithEngine(config, engine)
+	engine.Use(server.defaultLogger(), fallbackRecovery(), catchAbort)
+
+	return server
 }
 
+// NewServerWithEngine returns a server on engine. The access log is
+// installed on the engine, so the unmatched requests are logged too.
 func NewServerWithEngine(config Config, engine *gin.Engine) *Server {
-	return &Server{
-		config: config,
-		engine: engine,
+	metrics := NewMetricsRegistry()
+
+	server := &Server{
+		config:        config,
+		networks:      newConfigNetworks(config),
+		engine:        engine,
+		metrics:       metrics,
+		serverMetrics: newServerMetrics(metrics),
 	}
+	engine.Use(server.logAccess)
+	return server
 }
 
 type Server struct {
 	config      Config
+	configMutex sync.RWMutex
 	engine      *gin.Engine
 	routerGroup *gin.RouterGroup
+	routes      []Route
+
+	certificates    *certificateCache
+	networks        *configNetworks
+	reloadCallbacks []func(ReloadEvent)
+	panicCallbacks  []func(PanicEvent)
+	rateLimitStore  RateLimitStore
+	accessLog       *accessLogger
+	metrics         *MetricsRegistry
+	serverMetrics   *serverMetrics
+	spanTracer      *spanTracer
+
+	httpServers     []*http.Server
+	shuttingDown    int32
+	shutdowns       sync.WaitGroup
+	livenessChecks  []*HealthCheck
+	readinessChecks []*HealthCheck
+
+	concurrencyLimiters map[string]*concurrencyLimiter
+	// paths lists the methods registered for each path.
+	paths map[string][]string
+
+	cacheStore   CacheStore
+	cacheMutex   sync.Mutex
+	cacheFlights map[string]*cacheFlight
+	// cacheGenerations counts the invalidations of each resource, so the
+	// responses computed before one aren't stored after it.
+	cacheGenerations     map[string]uint64
+	cacheGenerationMutex sync.RWMutex
+
+	idempotencyStore IdempotencyStore
+}
+
+type Route struct {
+	Method string
+	Path   string
+	JSON   bool
+	// Action is the controller method of the routes added by AddResource
+	// and AddJSONResource, e.g. "Show".
+	Action string
+	// resource is the path of the resource of the routes added by
+	// AddJSONResource, whose changes invalidate its cached responses.
+	resource string
+
+	// public routes skip the HTTP auth, untimed ones the handler timeout,
+	// unlimited ones the concurrency limits and unthrottled ones the rate
+	// limits.
+	public      bool
+	untimed     bool
+	unlimited   bool
+	unthrottled bool
+
+	// contentSecurityPolicy replaces the default one for built-in pages.
+	contentSecurityPolicy string
 }
 
 const (
@@ -36,75 +107,237 @@ const (
 	POST   string = "POST"
 	PUT    string = "PUT"
 	DELETE string = "DELETE"
+	PATCH  string = "PATCH"
+
+	OPTIONS string = "OPTIONS"
 )
 
 func (s *Server) Run() error {
-	address := s.config.Hostname + ":" + strconv.Itoa(s.config.Port)
+	config := s.currentConfig()
+	if err := config.Validate(); err != nil {
+		return err
+	}
 
-	var err error
-	if s.config.TLS {
-		certificate, err := s.certificate()
-		if err != nil {
-			return err
-		}
-		publicKey, err := s.publicKey()
-		if err != nil {
-			return err
-		}
+	// Mounts the built-in endpoints even if no handler was added.
+	s.group()
 
-		err = http.ListenAndServeTLS(address, certificate, publicKey, s.engine)
-	} else {
-		err = http.ListenAndServe(address, s.engine)
+	// The listeners are registered before serving, so a concurrent Shutdown
+	// stops them all.
+	servers := []*http.Server{}
+	if config.Metrics.Enabled && config.Metrics.Address != "" {
+		servers = append(servers, s.metricsServer(config.Metrics))
+	}
+	if config.Admin.Enabled && config.Admin.Address != "" {
+		servers = append(servers, s.adminServer(config.Admin))
+	}
+	server, err := s.mainServer(config)
+	if err != nil {
+		return err
+	}
+	servers = append(servers, server)
+
+	if s.ShuttingDown() {
+		s.shutdowns.Wait()
+		return nil
+	}
+
+	errs := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *http.Server) { errs <- serve(server) }(server)
 	}
 
+	err = <-errs
+	if err == http.ErrServerClosed {
+		s.shutdowns.Wait()
+		return nil
+	}
+
+	// Stops the other listeners, so none outlives Run.
+	for _, server := range servers {
+		server.Close()
+	}
+	for range servers[1:] {
+		<-errs
+	}
 	return err
 }
 
+// Close releases the files opened by the server, such as the access log.
+// Call it once the server is stopped.
+func (s *Server) Close() error {
+	return s.closeAccessLog()
+}
+
+func (s *Server) mainServer(config Config) (*http.Server, error) {
+	server := s.newHTTPServer(config.Hostname+":"+strconv.Itoa(config.Port), s.engine)
+	config.Timeouts.applyTo(server)
+	server.MaxHeaderBytes = config.Limits.MaxHeaderBytes
+
+	if !config.TLS {
+		return server, nil
+	}
+
+	if _, err := s.certificateCache().get(config); err != nil {
+		return nil, err
+	}
+
+	server.TLSConfig = &tls.Config{GetCertificate: s.getCertificate}
+	return server, nil
+}
+
+// serve listens with server, over TLS when it has a TLS config.
+func serve(server *http.Server) error {
+	if server.TLSConfig != nil {
+		return server.ListenAndServeTLS("", "")
+	}
+	return server.ListenAndServe()
+}
+
 func (s *Server) AddHandler(method, path string, handler gin.HandlerFunc) {
-	method = strings.ToUpper(method)
-	switch method {
+	s.addHandler(Route{Method: method, Path: path}, handler)
+}
+
+func (s *Server) addHandler(route Route, handler gin.HandlerFunc) {
+	route.Method = strings.ToUpper(route.Method)
+	s.routes = append(s.routes, route)
+
+	s.handle(route, handler)
+}
+
+// handle registers the handlers behind the server middlewares. These run
+// for each route, after the route is stored in the context, so they can
+// tell requests apart by their route.
+func (s *Server) handle(route Route, handlers ...gin.HandlerFunc) {
+	chain := []gin.HandlerFunc{setRoute(route)}
+	chain = append(chain, s.middlewares(route)...)
+	chain = append(chain, handlers...)
+
+	switch route.Method {
 	case GET:
-		s.group().GET(path, handler)
+		s.group().GET(route.Path, chain...)
 	case POST:
-		s.group().POST(path, handler)
+		s.group().POST(route.Path, chain...)
 	case PUT:
-		s.group().PUT(path, handler)
+		s.group().PUT(route.Path, chain...)
 	case DELETE:
-		s.group().DELETE(path, handler)
+		s.group().DELETE(route.Path, chain...)
+	case PATCH:
+		s.group().PATCH(route.Path, chain...)
+	case OPTIONS:
+		s.group().OPTIONS(route.Path, chain...)
+	}
+
+	s.handlePreflight(route)
+}
+
+func (s *Server) middlewares(route Route) []gin.HandlerFunc {
+	middlewares := []gin.HandlerFunc{
+		s.resolveClient,
+		s.assignRequestID,
+		s.recordMetrics,
+		s.trace,
+		s.secureHeaders,
+		s.cors,
+		s.compress,
+		s.filterIP,
+		s.limitConcurrency,
+		s.recoverPanic,
+		s.enforceTimeout,
+		s.limitBody,
+		s.rateLimitClients,
+	}
+
+	if !route.public {
+		middlewares = append(middlewares, s.basicAuth)
 	}
+	// After the auth, to limit and replay by user.
+	return append(middlewares, s.rateLimitUsers, s.idempotent)
 }
 
 func (s *Server) AddJSONHandler(method, path string, handler JSONHandler) {
+	s.addJSONHandler(Route{Method: method, Path: path}, handler)
+}
+
+func (s *Server) addJSONHandler(route Route, handler JSONHandler) {
 	ginHandler := func(c *gin.Context) {
+		if route.Method == GET && s.serveCached(c, route, handler) {
+			return
+		}
+
 		data, err := handler(c)
 		if err != nil {
-			c.JSON(s.statusError(err), map[string]string{"error": err.Error()})
+			c.JSON(s.statusError(err), jsonError(c, err))
 			return
 		}
-		c.JSON(s.statusOK(method), data)
+		if route.Method != GET {
+			s.invalidateCache(route)
+		}
+		s.respondJSON(c, s.statusOK(route.Method), data)
 	}
-	s.AddHandler(method, path, ginHandler)
+
+	route.JSON = true
+	s.addHandler(route, ginHandler)
 }
 
+// AddJSONResource adds the routes of a JSON resource. Update is served on
+// both PUT and PATCH, the controller telling them apart by the method.
 func (s *Server) AddJSONResource(path string, controller JSONController) {
-	s.AddJSONHandler(GET, path, controller.Index)
-	s.AddJSONHandler(GET, path+"/:id", controller.Show)
-	s.AddJSONHandler(POST, path, controller.Create)
-	s.AddJSONHandler(PUT, path+"/:id", controller.Update)
-	s.AddJSONHandler(DELETE, path+"/:id", controller.Destroy)
+	s.addJSONHandler(Route{Method: GET, Path: path, Action: "Index", resource: path}, controller.Index)
+	s.addJSONHandler(Route{Method: GET, Path: path + "/:id", Action: "Show", resource: path}, controller.Show)
+	s.addJSONHandler(Route{Method: POST, Path: path, Action: "Create", resource: path}, controller.Create)
+	s.addJSONHandler(Route{Method: PUT, Path: path + "/:id", Action: "Update", resource: path}, s.ifMatch(controller.Show, controller.Update))
+	s.addJSONHandler(Route{Method: PATCH, Path: path + "/:id", Action: "Update", resource: path}, s.ifMatch(controller.Show, controller.Update))
+	s.addJSONHandler(Route{Method: DELETE, Path: path + "/:id", Action: "Destroy", resource: path}, s.ifMatch(controller.Show, controller.Destroy))
 }
 
 func (s *Server) AddResource(path string, controller Controller) {
-	s.AddHandler(GET, path, controller.Index)
-	s.AddHandler(GET, path+"/:id", controller.Show)
-	s.AddHandler(POST, path, controller.Create)
-	s.AddHandler(PUT, path+"/:id", controller.Update)
-	s.AddHandler(DELETE, path+"/:id", controller.Destroy)
+	s.addHandler(Route{Method: GET, Path: path, Action: "Index"}, controller.Index)
+	s.addHandler(Route{Method: GET, Path: path + "/:id", Action: "Show"}, controller.Show)
+	s.addHandler(Route{Method: POST, Path: path, Action: "Create"}, controller.Create)
+	s.addHandler(Route{Method: PUT, Path: path + "/:id", Action: "Update"}, controller.Update)
+	s.addHandler(Route{Method: DELETE, Path: path + "/:id", Action: "Destroy"}, controller.Destroy)
+}
+
+func (s *Server) currentConfig() Config {
+	s.configMutex.RLock()
+	defer s.configMutex.RUnlock()
+	return s.config
+}
+
+func (s *Server) Routes() []Route {
+	routes := make([]Route, len(s.routes))
+	copy(routes, s.routes)
+	return routes
+}
+
+func jsonError(c *gin.Context, err error) map[string]string {
+	return map[string]string{
+		"error":      err.Error(),
+		"request_id": RequestID(c),
+	}
+}
+
+// abortWithError responds with status and err, in the JSON error shape on
+// JSON routes, and stops the chain.
+func abortWithError(c *gin.Context, status int, err error) {
+	if route, _ := CurrentRoute(c); route.JSON {
+		c.JSON(status, jsonError(c, err))
+	} else {
+		c.String(status, err.Error())
+	}
+	c.Abort()
 }
 
 func (s *Server) statusError(err error) int {
-	if err == ErrNotFound {
+	switch {
+	case errors.Is(err, ErrNotFound):
 		return http.StatusNotFound
+	case errors.Is(err, ErrRequestTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrPreconditionFailed):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, ErrTimeout):
+		return http.StatusGatewayTimeout
 	}
 
 	return http.StatusInternalServerError
@@ -122,56 +355,10 @@ func (s *Server) group() *gin.RouterGroup {
 		return s.routerGroup
 	}
 
-	if len(s.config.HTTPAuth) == 0 {
-		s.routerGroup = s.engine.Group("/")
-		return s.routerGroup
-	}
-
-	accounts := gin.Accounts{}
-	for _, account := range s.config.HTTPAuth {
-		accounts[account.Username] = account.Password
-	}
-
-	s.routerGroup = s.engine.Group("/", gin.BasicAuth(accounts))
+	s.routerGroup = s.engine.Group("/")
+	s.mountExplorer()
+	s.mountMetrics()
+	s.mountHealth()
+	s.mountAdmin()
 	return s.routerGroup
 }
-
-func (s *Server) certificate() (string, error) {
-	if len(s.config.Certificate) < 500 {
-		return s.config.Certificate, nil
-	}
-
-	certFile, err := ioutil.TempFile("", "cert")
-	if err != nil {
-		return "", err
-	}
-
-	_, err = certFile.Write([]byte(s.config.Certificate))
-	if err != nil {
-		return "", err
-	}
-
-	certFile.Close()
-
-	return certFile.Name(), nil
-}
-
-func (s *Server) publicKey() (string, error) {
-	if len(s.config.PublicKey) < 500 {
-		return s.config.PublicKey, nil
-	}
-
-	keyFile, err := ioutil.TempFile("", "key")
-	if err != nil {
-		return "", err
-	}
-
-	_, err = keyFile.Write([]byte(s.config.PublicKey))
-	if err != nil {
-		return "", err
-	}
-
-	keyFile.Close()
-
-	return keyFile.Name(), nil
-}
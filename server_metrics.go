@@ -0,0 +1,138 @@
+package thruster
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultMetricsPath = "/metrics"
+
+type serverMetrics struct {
+	requests           *Counter
+	duration           *Histogram
+	inFlight           *Gauge
+	responseSize       *Histogram
+	tlsHandshakeErrors *Counter
+	authFailures       *Counter
+	panics             *Counter
+	rateLimited        *Counter
+	shed               *Counter
+	ipDenied           *Counter
+}
+
+func newServerMetrics(registry *MetricsRegistry) *serverMetrics {
+	return &serverMetrics{
+		requests: registry.NewCounter("thruster_http_requests_total",
+			"Number of HTTP requests handled.", "method", "route", "status"),
+		duration: registry.NewHistogram("thruster_http_request_duration_seconds",
+			"Time spent handling HTTP requests.", DefaultLatencyBuckets, "method", "route", "status"),
+		inFlight: registry.NewGauge("thruster_http_requests_in_flight",
+			"Number of HTTP requests being handled."),
+		responseSize: registry.NewHistogram("thruster_http_response_size_bytes",
+			"Size of the HTTP responses.", DefaultSizeBuckets, "method", "route"),
+		tlsHandshakeErrors: registry.NewCounter("thruster_tls_handshake_errors_total",
+			"Number of failed TLS handshakes."),
+		authFailures: registry.NewCounter("thruster_http_auth_failures_total",
+			"Number of requests rejected by the HTTP auth.", "route"),
+		panics: registry.NewCounter("thruster_http_panics_total",
+			"Number of handler panics recovered.", "route"),
+		rateLimited: registry.NewCounter("thruster_http_rate_limited_total",
+			"Number of requests rejected by the rate limit.", "route"),
+		shed: registry.NewCounter("thruster_http_shed_total",
+			"Number of requests shed by the concurrency limits.", "route", "reason"),
+		ipDenied: registry.NewCounter("thruster_http_ip_denied_total",
+			"Number of requests rejected by the IP filter.", "route"),
+	}
+}
+
+// Metrics returns the registry served by the metrics endpoint. Applications
+// can register their own metrics in it.
+func (s *Server) Metrics() *MetricsRegistry {
+	return s.metrics
+}
+
+// MetricsHandler serves the metrics in the Prometheus text format.
+func (s *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		s.metrics.WriteTo(w)
+	})
+}
+
+func (m Metrics) path() string {
+	if m.Path == "" {
+		return defaultMetricsPath
+	}
+	return m.Path
+}
+
+func (s *Server) mountMetrics() {
+	metrics := s.currentConfig().Metrics
+	if !metrics.Enabled || metrics.Address != "" {
+		return
+	}
+
+	handler := s.MetricsHandler()
+	s.handle(Route{Method: GET, Path: metrics.path(), unlimited: true}, func(c *gin.Context) {
+		handler.ServeHTTP(c.Writer, c.Request)
+	})
+}
+
+func (s *Server) metricsServer(metrics Metrics) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle(metrics.path(), s.MetricsHandler())
+	return s.newHTTPServer(metrics.Address, mux)
+}
+
+func (s *Server) recordMetrics(c *gin.Context) {
+	if !s.currentConfig().Metrics.Enabled {
+		return
+	}
+
+	start := time.Now()
+	s.serverMetrics.inFlight.Inc()
+	defer s.serverMetrics.inFlight.Dec()
+
+	c.Next()
+
+	route := RoutePath(c)
+	status := c.Writer.Status()
+	size := c.Writer.Size()
+	if size < 0 {
+		size = 0
+	}
+
+	s.serverMetrics.requests.Inc(c.Request.Method, route, statusClass(status))
+	s.serverMetrics.duration.Observe(time.Since(start).Seconds(), c.Request.Method, route, statusClass(status))
+	s.serverMetrics.responseSize.Observe(float64(size), c.Request.Method, route)
+}
+
+// statusClass groups the status codes, e.g. 404 -> "4xx".
+func statusClass(status int) string {
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// errorLog returns the logger for the http.Server errors, counting the
+// failed TLS handshakes it reports.
+func (m *serverMetrics) errorLog() *log.Logger {
+	return log.New(&errorLogWriter{metrics: m}, "", log.LstdFlags)
+}
+
+type errorLogWriter struct {
+	metrics *serverMetrics
+}
+
+var tlsHandshakeError = []byte("TLS handshake error")
+
+func (w *errorLogWriter) Write(data []byte) (int, error) {
+	if bytes.Contains(data, tlsHandshakeError) {
+		w.metrics.tlsHandshakeErrors.Inc()
+	}
+	return os.Stderr.Write(data)
+}
@@ -1,7 +1,9 @@
 package thruster_test
 
 import (
+	"net"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	. "github.com/onsi/ginkgo"
@@ -27,9 +29,18 @@ func makeSimpleRequest(method, url string) *http.Response {
 	return resp
 }
 
-func startServer(server *thruster.Server) {
+func startServer(server *thruster.Server, port int) {
 	go func() {
+		defer GinkgoRecover()
 		err := server.Run()
 		Expect(err).ToNot(HaveOccurred())
 	}()
+
+	Eventually(func() error {
+		conn, err := net.Dial("tcp", "localhost:"+strconv.Itoa(port))
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}).Should(Succeed())
 }
@@ -252,7 +252,7 @@ var _ = Describe("Server", func() {
 
 		Context("server url and port", func() {
 			It("listens to the hostname and port in the configuration", func() {
-				startServer(subject)
+				startServer(subject, port)
 				resp, err := http.Get("http://localhost:" + strconv.Itoa(port) + "/test")
 				Expect(err).ToNot(HaveOccurred())
 				Expect(resp.StatusCode).To(Equal(http.StatusOK))
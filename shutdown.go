@@ -0,0 +1,57 @@
+package thruster
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// newHTTPServer returns an http.Server for a listener of the server, tracked
+// so Shutdown can stop it.
+func (s *Server) newHTTPServer(address string, handler http.Handler) *http.Server {
+	server := &http.Server{
+		Addr:     address,
+		Handler:  handler,
+		ErrorLog: s.serverMetrics.errorLog(),
+	}
+
+	s.configMutex.Lock()
+	s.httpServers = append(s.httpServers, server)
+	s.configMutex.Unlock()
+
+	return server
+}
+
+// Shutdown gracefully stops the server. Readiness starts failing right
+// away, and after Health.ShutdownDelay, giving load balancers time to stop
+// sending traffic, the listeners are closed and the requests in progress
+// are waited for, until ctx is done. Run then returns nil, once Shutdown
+// returns.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shutdowns.Add(1)
+	defer s.shutdowns.Done()
+	atomic.StoreInt32(&s.shuttingDown, 1)
+
+	select {
+	case <-time.After(s.currentConfig().Health.ShutdownDelay):
+	case <-ctx.Done():
+	}
+
+	s.configMutex.RLock()
+	servers := s.httpServers
+	s.configMutex.RUnlock()
+
+	var err error
+	for _, server := range servers {
+		if shutdownErr := server.Shutdown(ctx); shutdownErr != nil && err == nil {
+			err = shutdownErr
+		}
+	}
+	return err
+}
+
+// ShuttingDown reports if Shutdown was called.
+func (s *Server) ShuttingDown() bool {
+	return atomic.LoadInt32(&s.shuttingDown) == 1
+}
@@ -0,0 +1,236 @@
+package thruster
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handlerTimeout returns the deadline budget of route, 0 meaning none.
+func (t Timeouts) handlerTimeout(route Route) time.Duration {
+	if timeout, found := t.Routes[route.Method+" "+route.Path]; found {
+		return timeout
+	}
+	return t.Handler
+}
+
+// applyTo sets the server-level timeouts on the main listener.
+func (t Timeouts) applyTo(server *http.Server) {
+	server.ReadTimeout = t.Read
+	server.ReadHeaderTimeout = t.ReadHeader
+	server.WriteTimeout = t.Write
+	server.IdleTimeout = t.Idle
+}
+
+// enforceTimeout cancels the request context once the route deadline is
+// over. If the handler hasn't started its response by then, a 504 is sent
+// right away, with the JSON error shape on JSON routes, and its later
+// writes fail with http.ErrHandlerTimeout. Handlers should stop when the
+// context is done, as the connection is held until they return.
+func (s *Server) enforceTimeout(c *gin.Context) {
+	route, _ := CurrentRoute(c)
+	timeout := s.currentConfig().Timeouts.handlerTimeout(route)
+	if timeout <= 0 || route.untimed {
+		return
+	}
+
+	ctx, cancel := context.WithCancelCause(c.Request.Context())
+	defer cancel(nil)
+	c.Request = c.Request.WithContext(newDeadlineContext(ctx, time.Now().Add(timeout)))
+
+	// The handlers run on a context of their own, as gin's renderers set the
+	// status on the context's embedded writer directly, which would race the
+	// 504 of the timer. Copy only provides a context whose Writer is its own
+	// embedded one; everything else is taken over from c, except Keys, which
+	// are handed back once the handlers return.
+	handler := c.Copy()
+	renderer := handler.Writer
+	*handler = *c
+	handler.Keys = make(map[string]interface{}, len(c.Keys))
+	for key, value := range c.Keys {
+		handler.Keys[key] = value
+	}
+	writer := &timeoutWriter{
+		ResponseWriter: c.Writer,
+		renderer:       renderer,
+		header:         http.Header{},
+	}
+	for key, values := range c.Writer.Header() {
+		writer.header[key] = values
+	}
+	handler.Writer = writer
+
+	errorBody := []byte(http.StatusText(http.StatusGatewayTimeout))
+	contentType := "text/plain; charset=utf-8"
+	if route.JSON {
+		errorBody, _ = json.Marshal(jsonError(c, ErrTimeout))
+		contentType = "application/json; charset=utf-8"
+	}
+
+	timer := time.AfterFunc(timeout, func() {
+		writer.timeout(contentType, errorBody)
+		cancel(context.DeadlineExceeded)
+	})
+
+	defer func() {
+		timer.Stop()
+		writer.finish()
+		c.Keys, c.Errors = handler.Keys, handler.Errors
+	}()
+
+	handler.Next()
+	c.Abort()
+}
+
+// deadlineContext is cancelled by the timeout timer once the 504 is sent,
+// so handlers don't race it to the response, and reports the deadline like
+// a context.WithTimeout one.
+type deadlineContext struct {
+	context.Context
+	deadline time.Time
+}
+
+func newDeadlineContext(parent context.Context, deadline time.Time) *deadlineContext {
+	if parentDeadline, ok := parent.Deadline(); ok && parentDeadline.Before(deadline) {
+		deadline = parentDeadline
+	}
+	return &deadlineContext{Context: parent, deadline: deadline}
+}
+
+func (c *deadlineContext) Deadline() (time.Time, bool) {
+	return c.deadline, true
+}
+
+func (c *deadlineContext) Err() error {
+	if c.Context.Err() == nil {
+		return nil
+	}
+	return context.Cause(c.Context)
+}
+
+// timeoutWriter keeps the handler headers and status apart from the
+// response ones until the handler starts its response, so a 504 can be sent
+// from the timer goroutine in the meantime.
+type timeoutWriter struct {
+	gin.ResponseWriter
+	// renderer is the writer of the handlers' context, which gin's
+	// renderers set the status on.
+	renderer gin.ResponseWriter
+
+	mutex       sync.Mutex
+	header      http.Header
+	wroteHeader bool
+	timedOut    bool
+	done        bool
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if !w.wroteHeader && !w.timedOut {
+		w.renderer.WriteHeader(code)
+	}
+}
+
+func (w *timeoutWriter) WriteHeaderNow() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.writeHeader()
+}
+
+func (w *timeoutWriter) Write(data []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	w.writeHeader()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *timeoutWriter) WriteString(data string) (int, error) {
+	return w.Write([]byte(data))
+}
+
+func (w *timeoutWriter) Flush() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.timedOut {
+		return
+	}
+	w.writeHeader()
+	w.ResponseWriter.Flush()
+}
+
+func (w *timeoutWriter) Status() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.wroteHeader || w.timedOut {
+		return w.ResponseWriter.Status()
+	}
+	return w.renderer.Status()
+}
+
+func (w *timeoutWriter) Size() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.ResponseWriter.Size()
+}
+
+func (w *timeoutWriter) Written() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.wroteHeader || w.timedOut
+}
+
+func (w *timeoutWriter) copyHeader() {
+	header := w.ResponseWriter.Header()
+	for key, values := range w.header {
+		header[key] = values
+	}
+	w.ResponseWriter.WriteHeader(w.renderer.Status())
+}
+
+func (w *timeoutWriter) writeHeader() {
+	if w.wroteHeader || w.timedOut {
+		return
+	}
+	w.wroteHeader = true
+	w.copyHeader()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *timeoutWriter) timeout(contentType string, body []byte) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.wroteHeader || w.done {
+		return
+	}
+
+	w.timedOut = true
+	header := w.ResponseWriter.Header()
+	header.Set("Content-Type", contentType)
+	header.Del("Content-Length")
+	w.ResponseWriter.WriteHeader(http.StatusGatewayTimeout)
+	w.ResponseWriter.Write(body)
+	w.ResponseWriter.Flush()
+}
+
+// finish hands the headers of a handler that returned without writing a
+// body back to the response, for gin to write them.
+func (w *timeoutWriter) finish() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.done = true
+	if !w.wroteHeader && !w.timedOut {
+		w.copyHeader()
+	}
+}
@@ -0,0 +1,132 @@
+package thruster_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tscolari/thruster"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Timeouts", func() {
+	var config thruster.Config
+	var testServer *httptest.Server
+	var cancelled chan error
+
+	BeforeEach(func() {
+		cancelled = make(chan error, 1)
+		config = thruster.Config{
+			Timeouts: thruster.Timeouts{Handler: 50 * time.Millisecond},
+		}
+	})
+
+	JustBeforeEach(func() {
+		engine := gin.New()
+		subject := thruster.NewServerWithEngine(config, engine)
+
+		done := cancelled
+		subject.AddJSONHandler(thruster.GET, "/slow", func(c *gin.Context) (interface{}, error) {
+			<-c.Request.Context().Done()
+			done <- c.Request.Context().Err()
+			return "late", nil
+		})
+		subject.AddJSONHandler(thruster.GET, "/expired", func(c *gin.Context) (interface{}, error) {
+			return nil, thruster.ErrTimeout
+		})
+		subject.AddHandler(thruster.GET, "/plain", func(c *gin.Context) {
+			<-c.Request.Context().Done()
+			c.String(200, "late")
+		})
+		subject.AddHandler(thruster.GET, "/sleepy", func(c *gin.Context) {
+			time.Sleep(100 * time.Millisecond)
+			c.JSON(200, "late")
+		})
+		subject.AddHandler(thruster.GET, "/fast", func(c *gin.Context) {
+			c.Header("X-Custom", "yes")
+			c.String(http.StatusAccepted, "OK")
+		})
+		testServer = httptest.NewServer(engine)
+	})
+
+	AfterEach(func() {
+		testServer.Close()
+	})
+
+	It("cancels the request context and responds with a JSON 504", func() {
+		resp := makeSimpleRequest(thruster.GET, testServer.URL+"/slow")
+		Expect(resp.StatusCode).To(Equal(http.StatusGatewayTimeout))
+
+		body := map[string]string{}
+		Expect(json.NewDecoder(resp.Body).Decode(&body)).To(Succeed())
+		Expect(body["error"]).To(Equal(thruster.ErrTimeout.Error()))
+		Expect(body["request_id"]).To(Equal(resp.Header.Get("X-Request-ID")))
+
+		Eventually(cancelled).Should(Receive(HaveOccurred()))
+	})
+
+	It("responds with a plain 504 on other routes", func() {
+		resp := makeSimpleRequest(thruster.GET, testServer.URL+"/plain")
+		Expect(resp.StatusCode).To(Equal(http.StatusGatewayTimeout))
+
+		body, _ := ioutil.ReadAll(resp.Body)
+		Expect(string(body)).To(Equal("Gateway Timeout"))
+	})
+
+	It("keeps the handlers ignoring the context from the response", func() {
+		resp := makeSimpleRequest(thruster.GET, testServer.URL+"/sleepy")
+		Expect(resp.StatusCode).To(Equal(http.StatusGatewayTimeout))
+
+		body, _ := ioutil.ReadAll(resp.Body)
+		Expect(string(body)).To(Equal("Gateway Timeout"))
+	})
+
+	It("responds with a 504 to JSON handlers returning ErrTimeout", func() {
+		resp := makeSimpleRequest(thruster.GET, testServer.URL+"/expired")
+		Expect(resp.StatusCode).To(Equal(http.StatusGatewayTimeout))
+
+		body := map[string]string{}
+		Expect(json.NewDecoder(resp.Body).Decode(&body)).To(Succeed())
+		Expect(body["error"]).To(Equal(thruster.ErrTimeout.Error()))
+	})
+
+	It("doesn't change the responses in time", func() {
+		resp := makeSimpleRequest(thruster.GET, testServer.URL+"/fast")
+		Expect(resp.StatusCode).To(Equal(http.StatusAccepted))
+		Expect(resp.Header.Get("X-Custom")).To(Equal("yes"))
+		Expect(resp.Header.Get("X-Request-ID")).ToNot(BeEmpty())
+
+		body, _ := ioutil.ReadAll(resp.Body)
+		Expect(string(body)).To(Equal("OK"))
+	})
+
+	Context("with a route timeout", func() {
+		BeforeEach(func() {
+			config.Timeouts.Handler = 0
+			config.Timeouts.Routes = map[string]time.Duration{"GET /plain": 20 * time.Millisecond}
+		})
+
+		It("applies it to that route only", func() {
+			resp := makeSimpleRequest(thruster.GET, testServer.URL+"/plain")
+			Expect(resp.StatusCode).To(Equal(http.StatusGatewayTimeout))
+		})
+	})
+
+	Describe("validation", func() {
+		It("rejects negative timeouts and malformed route keys", func() {
+			config := thruster.Config{Port: 8080, Timeouts: thruster.Timeouts{
+				Idle:   -time.Second,
+				Routes: map[string]time.Duration{"/users": time.Second},
+			}}
+
+			err := config.Validate()
+			Expect(err).To(MatchError(ContainSubstring("timeouts.idle")))
+			Expect(err).To(MatchError(ContainSubstring(`"/users"`)))
+		})
+	})
+})
@@ -0,0 +1,260 @@
+package thruster
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	mathrand "math/rand"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	spanKey = "thruster.span"
+
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+)
+
+var traceparentPattern = regexp.MustCompile(`^([0-9a-f]{2})-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})$`)
+
+// Span is the server span of a request, following the W3C Trace Context.
+type Span struct {
+	TraceID    string                 `json:"trace_id"`
+	SpanID     string                 `json:"span_id"`
+	ParentID   string                 `json:"parent_id,omitempty"`
+	TraceState string                 `json:"trace_state,omitempty"`
+	Sampled    bool                   `json:"sampled"`
+	Name       string                 `json:"name"`
+	Start      time.Time              `json:"start"`
+	End        time.Time              `json:"end"`
+	Attributes map[string]interface{} `json:"attributes"`
+	Error      bool                   `json:"error"`
+}
+
+// Traceparent returns the `traceparent` header value that propagates this
+// span as the parent of outgoing requests.
+func (s *Span) Traceparent() string {
+	flags := "00"
+	if s.Sampled {
+		flags = "01"
+	}
+	return "00-" + s.TraceID + "-" + s.SpanID + "-" + flags
+}
+
+// SetAttribute adds an attribute to the span, to be exported with it.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	s.Attributes[key] = value
+}
+
+// SpanExporter sends finished spans to a tracing backend. ExportSpans is
+// called from a single goroutine, with the spans batched as they arrive.
+type SpanExporter interface {
+	ExportSpans(spans []Span) error
+}
+
+// SpanFromContext returns the span of the request, or nil when tracing is
+// disabled.
+func SpanFromContext(c *gin.Context) *Span {
+	if span, ok := c.Get(spanKey); ok {
+		return span.(*Span)
+	}
+	return nil
+}
+
+type spanContextKey struct{}
+
+// SpanFromRequestContext returns the span stored in the request context,
+// for code that only has access to the context.Context.
+func SpanFromRequestContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey{}).(*Span)
+	return span
+}
+
+// SetSpanExporter exports the spans with exporter, instead of the one in the
+// tracing config.
+func (s *Server) SetSpanExporter(exporter SpanExporter) {
+	s.tracer().setExporter(exporter, "")
+}
+
+func (s *Server) trace(c *gin.Context) {
+	config := s.currentConfig().Tracing
+	if !config.Enabled {
+		return
+	}
+
+	span := startSpan(c, config)
+	c.Set(spanKey, span)
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), spanContextKey{}, span))
+
+	c.Next()
+
+	status := c.Writer.Status()
+	span.End = time.Now()
+	span.Error = status >= 500
+	span.Attributes["http.status_code"] = status
+	if user := AuthenticatedUser(c); user != "" {
+		span.Attributes["enduser.id"] = user
+	}
+
+	if span.Sampled {
+		s.tracer().export(*span, config)
+	}
+}
+
+func startSpan(c *gin.Context, config Tracing) *Span {
+	route, _ := CurrentRoute(c)
+
+	span := &Span{
+		SpanID: randomHex(8),
+		Name:   route.Method + " " + route.Path,
+		Start:  time.Now(),
+		Attributes: map[string]interface{}{
+			"http.method":     c.Request.Method,
+			"http.route":      route.Path,
+			"http.target":     c.Request.URL.RequestURI(),
+			"http.client_ip":  ClientIP(c),
+			"http.user_agent": c.Request.UserAgent(),
+			"http.request_id": RequestID(c),
+		},
+	}
+
+	if route.Action != "" {
+		span.Name += " (" + route.Action + ")"
+		span.Attributes["thruster.action"] = route.Action
+	}
+
+	matches := traceparentPattern.FindStringSubmatch(c.Request.Header.Get(TraceparentHeader))
+	if matches != nil && matches[1] != "ff" && strings.Trim(matches[2], "0") != "" && strings.Trim(matches[3], "0") != "" {
+		span.TraceID = matches[2]
+		span.ParentID = matches[3]
+		flags, _ := strconv.ParseUint(matches[4], 16, 8)
+		span.Sampled = flags&1 == 1
+		span.TraceState = c.Request.Header.Get(TracestateHeader)
+	} else {
+		span.TraceID = randomHex(16)
+		span.Sampled = config.SampleRate <= 0 || config.SampleRate >= 1 || mathrand.Float64() < config.SampleRate
+	}
+
+	return span
+}
+
+func randomHex(size int) string {
+	id := make([]byte, size)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
+
+// spanTracer exports spans in the background, so slow backends don't delay
+// the responses. Spans are dropped when the queue is full.
+type spanTracer struct {
+	mutex    sync.Mutex
+	exporter SpanExporter
+	source   string
+	queue    chan Span
+	logf     func(level, format string, args ...interface{})
+}
+
+const spanQueueSize = 1024
+const spanBatchSize = 128
+
+func (s *Server) tracer() *spanTracer {
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+
+	if s.spanTracer == nil {
+		s.spanTracer = &spanTracer{queue: make(chan Span, spanQueueSize), logf: s.logf}
+		go s.spanTracer.run()
+	}
+	return s.spanTracer
+}
+
+func (t *spanTracer) setExporter(exporter SpanExporter, source string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.exporter = exporter
+	t.source = source
+}
+
+func (t *spanTracer) export(span Span, config Tracing) {
+	if err := t.configure(config); err != nil {
+		t.logf(LogLevelWarn, "tracing: %s", err)
+		return
+	}
+
+	select {
+	case t.queue <- span:
+	default:
+	}
+}
+
+// configure sets the exporter described by the config, unless one was set
+// with SetSpanExporter.
+func (t *spanTracer) configure(config Tracing) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	source := fmt.Sprintf("%s:%s:%s:%s", config.Exporter, config.File, config.Endpoint, config.ServiceName)
+	if t.exporter != nil && (t.source == "" || t.source == source) {
+		return nil
+	}
+
+	exporter, err := newSpanExporter(config)
+	if err != nil {
+		return err
+	}
+
+	if closer, ok := t.exporter.(interface {
+		Close() error
+	}); ok {
+		closer.Close()
+	}
+
+	t.exporter = exporter
+	t.source = source
+	return nil
+}
+
+func (t *spanTracer) run() {
+	for span := range t.queue {
+		batch := []Span{span}
+	drain:
+		for len(batch) < spanBatchSize {
+			select {
+			case span := <-t.queue:
+				batch = append(batch, span)
+			default:
+				break drain
+			}
+		}
+
+		t.mutex.Lock()
+		exporter := t.exporter
+		t.mutex.Unlock()
+
+		if err := exporter.ExportSpans(batch); err != nil {
+			t.logf(LogLevelWarn, "tracing: %s", err)
+		}
+	}
+}
+
+func newSpanExporter(config Tracing) (SpanExporter, error) {
+	switch config.Exporter {
+	case "", "stdout":
+		return NewJSONSpanExporter(os.Stdout), nil
+	case "file":
+		return NewJSONFileSpanExporter(config.File)
+	case "otlp":
+		return NewOTLPSpanExporter(config.Endpoint, config.ServiceName), nil
+	}
+	return nil, fmt.Errorf("unknown span exporter %q", config.Exporter)
+}
@@ -0,0 +1,189 @@
+package thruster
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const DefaultOTLPEndpoint = "http://localhost:4318/v1/traces"
+
+// JSONSpanExporter writes each span as a line of JSON.
+type JSONSpanExporter struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+func NewJSONSpanExporter(writer io.Writer) *JSONSpanExporter {
+	return &JSONSpanExporter{writer: writer}
+}
+
+// NewJSONFileSpanExporter appends the spans to the file at path.
+func NewJSONFileSpanExporter(path string) (*JSONSpanExporter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return NewJSONSpanExporter(file), nil
+}
+
+func (e *JSONSpanExporter) ExportSpans(spans []Span) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	encoder := json.NewEncoder(e.writer)
+	for _, span := range spans {
+		if err := encoder.Encode(span); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *JSONSpanExporter) Close() error {
+	if file, ok := e.writer.(*os.File); ok && file != os.Stdout && file != os.Stderr {
+		return file.Close()
+	}
+	return nil
+}
+
+// OTLPSpanExporter sends the spans to an OpenTelemetry collector, using the
+// OTLP/HTTP protocol with JSON encoding.
+type OTLPSpanExporter struct {
+	Endpoint    string
+	ServiceName string
+	Headers     map[string]string
+	Client      *http.Client
+}
+
+func NewOTLPSpanExporter(endpoint, serviceName string) *OTLPSpanExporter {
+	if endpoint == "" {
+		endpoint = DefaultOTLPEndpoint
+	}
+
+	return &OTLPSpanExporter{
+		Endpoint:    endpoint,
+		ServiceName: serviceName,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (e *OTLPSpanExporter) ExportSpans(spans []Span) error {
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest(POST, e.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range e.Headers {
+		request.Header.Set(key, value)
+	}
+
+	resp, err := e.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("otlp exporter: %s responded %d", e.Endpoint, resp.StatusCode)
+	}
+	return nil
+}
+
+type otlpValue map[string]interface{}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+const (
+	otlpSpanKindServer  = 2
+	otlpStatusCodeUnset = 0
+	otlpStatusCodeError = 2
+)
+
+func (e *OTLPSpanExporter) request(spans []Span) map[string]interface{} {
+	otlpSpans := []map[string]interface{}{}
+	for _, span := range spans {
+		status := otlpStatusCodeUnset
+		if span.Error {
+			status = otlpStatusCodeError
+		}
+
+		otlpSpan := map[string]interface{}{
+			"traceId":           span.TraceID,
+			"spanId":            span.SpanID,
+			"name":              span.Name,
+			"kind":              otlpSpanKindServer,
+			"startTimeUnixNano": strconv.FormatInt(span.Start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(span.End.UnixNano(), 10),
+			"attributes":        otlpAttributes(span.Attributes),
+			"status":            map[string]interface{}{"code": status},
+		}
+		if span.ParentID != "" {
+			otlpSpan["parentSpanId"] = span.ParentID
+		}
+		if span.TraceState != "" {
+			otlpSpan["traceState"] = span.TraceState
+		}
+		otlpSpans = append(otlpSpans, otlpSpan)
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": otlpAttributes(map[string]interface{}{"service.name": e.ServiceName}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "github.com/tscolari/thruster"},
+						"spans": otlpSpans,
+					},
+				},
+			},
+		},
+	}
+}
+
+func otlpAttributes(attributes map[string]interface{}) []otlpAttribute {
+	keys := []string{}
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	otlp := []otlpAttribute{}
+	for _, key := range keys {
+		var value otlpValue
+		switch v := attributes[key].(type) {
+		case bool:
+			value = otlpValue{"boolValue": v}
+		case int:
+			value = otlpValue{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = otlpValue{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = otlpValue{"doubleValue": v}
+		default:
+			value = otlpValue{"stringValue": fmt.Sprint(v)}
+		}
+		otlp = append(otlp, otlpAttribute{Key: key, Value: value})
+	}
+	return otlp
+}
@@ -0,0 +1,233 @@
+package thruster_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tscolari/thruster"
+	"github.com/tscolari/thruster/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type channelSpanExporter chan thruster.Span
+
+func (e channelSpanExporter) ExportSpans(spans []thruster.Span) error {
+	for _, span := range spans {
+		e <- span
+	}
+	return nil
+}
+
+var _ = Describe("Tracing", func() {
+	var subject *thruster.Server
+	var config thruster.Config
+	var testServer *httptest.Server
+	var exported channelSpanExporter
+	var handlerSpan *thruster.Span
+
+	requestWithHeaders := func(method, url string, headers map[string]string) *http.Response {
+		request, err := http.NewRequest(method, url, nil)
+		Expect(err).ToNot(HaveOccurred())
+		for key, value := range headers {
+			request.Header.Set(key, value)
+		}
+		resp, err := http.DefaultClient.Do(request)
+		Expect(err).ToNot(HaveOccurred())
+		return resp
+	}
+
+	BeforeEach(func() {
+		config = thruster.Config{
+			Tracing: thruster.Tracing{Enabled: true, ServiceName: "users"},
+		}
+		exported = make(channelSpanExporter, 10)
+		handlerSpan = nil
+	})
+
+	JustBeforeEach(func() {
+		engine := gin.New()
+		subject = thruster.NewServerWithEngine(config, engine)
+		subject.SetSpanExporter(exported)
+		subject.AddHandler(thruster.GET, "/test", func(c *gin.Context) {
+			handlerSpan = thruster.SpanFromContext(c)
+			Expect(thruster.SpanFromRequestContext(c.Request.Context())).To(Equal(handlerSpan))
+			c.String(200, "OK")
+		})
+		subject.AddJSONResource("/users", &fakes.FakeJSONController{})
+		subject.AddJSONHandler(thruster.GET, "/fail", func(c *gin.Context) (interface{}, error) {
+			return nil, errors.New("failed")
+		})
+		testServer = httptest.NewServer(engine)
+	})
+
+	AfterEach(func() {
+		testServer.Close()
+	})
+
+	It("starts a server span per request, available to the handlers", func() {
+		makeSimpleRequest(thruster.GET, testServer.URL+"/test?q=1")
+
+		var span thruster.Span
+		Eventually(exported).Should(Receive(&span))
+		Expect(handlerSpan).ToNot(BeNil())
+		Expect(span.SpanID).To(Equal(handlerSpan.SpanID))
+		Expect(span.TraceID).To(MatchRegexp("^[0-9a-f]{32}$"))
+		Expect(span.SpanID).To(MatchRegexp("^[0-9a-f]{16}$"))
+		Expect(span.ParentID).To(BeEmpty())
+		Expect(span.Sampled).To(BeTrue())
+		Expect(span.Name).To(Equal("GET /test"))
+		Expect(span.Attributes).To(HaveKeyWithValue("http.route", "/test"))
+		Expect(span.Attributes).To(HaveKeyWithValue("http.target", "/test?q=1"))
+		Expect(span.Attributes).To(HaveKeyWithValue("http.status_code", 200))
+		Expect(span.End).To(BeTemporally(">=", span.Start))
+	})
+
+	It("names resource spans after the route template and action", func() {
+		makeSimpleRequest(thruster.PUT, testServer.URL+"/users/1")
+
+		var span thruster.Span
+		Eventually(exported).Should(Receive(&span))
+		Expect(span.Name).To(Equal("PUT /users/:id (Update)"))
+		Expect(span.Attributes).To(HaveKeyWithValue("thruster.action", "Update"))
+	})
+
+	It("continues the trace from the traceparent header", func() {
+		requestWithHeaders(thruster.GET, testServer.URL+"/test", map[string]string{
+			"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			"tracestate":  "vendor=value",
+		})
+
+		var span thruster.Span
+		Eventually(exported).Should(Receive(&span))
+		Expect(span.TraceID).To(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+		Expect(span.ParentID).To(Equal("00f067aa0ba902b7"))
+		Expect(span.TraceState).To(Equal("vendor=value"))
+		Expect(handlerSpan.Traceparent()).To(Equal("00-4bf92f3577b34da6a3ce929d0e0e4736-" + span.SpanID + "-01"))
+	})
+
+	It("doesn't export traces not sampled upstream", func() {
+		requestWithHeaders(thruster.GET, testServer.URL+"/test", map[string]string{
+			"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+		})
+		Consistently(exported).ShouldNot(Receive())
+		Expect(handlerSpan.TraceID).To(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+	})
+
+	It("starts a new trace when the traceparent is invalid", func() {
+		requestWithHeaders(thruster.GET, testServer.URL+"/test", map[string]string{
+			"traceparent": "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		})
+
+		var span thruster.Span
+		Eventually(exported).Should(Receive(&span))
+		Expect(span.TraceID).ToNot(Equal("00000000000000000000000000000000"))
+		Expect(span.ParentID).To(BeEmpty())
+	})
+
+	It("flags server errors", func() {
+		makeSimpleRequest(thruster.GET, testServer.URL+"/fail")
+
+		var span thruster.Span
+		Eventually(exported).Should(Receive(&span))
+		Expect(span.Error).To(BeTrue())
+		Expect(span.Attributes).To(HaveKeyWithValue("http.status_code", 500))
+	})
+
+	Context("when disabled", func() {
+		BeforeEach(func() {
+			config.Tracing.Enabled = false
+		})
+
+		It("doesn't trace", func() {
+			makeSimpleRequest(thruster.GET, testServer.URL+"/test")
+			Consistently(exported).ShouldNot(Receive())
+			Expect(handlerSpan).To(BeNil())
+		})
+	})
+
+	Describe("JSONSpanExporter", func() {
+		It("writes the spans as JSON lines to a file", func() {
+			dir, err := ioutil.TempDir("", "thruster")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			exporter, err := thruster.NewJSONFileSpanExporter(filepath.Join(dir, "spans.json"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(exporter.ExportSpans([]thruster.Span{
+				{TraceID: "t1", SpanID: "s1", Name: "GET /a"},
+				{TraceID: "t2", SpanID: "s2", Name: "GET /b"},
+			})).To(Succeed())
+
+			data, err := ioutil.ReadFile(filepath.Join(dir, "spans.json"))
+			Expect(err).ToNot(HaveOccurred())
+			lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+			Expect(lines).To(HaveLen(2))
+
+			var span thruster.Span
+			Expect(json.Unmarshal([]byte(lines[1]), &span)).To(Succeed())
+			Expect(span.Name).To(Equal("GET /b"))
+		})
+	})
+
+	Describe("OTLPSpanExporter", func() {
+		It("posts the spans to the collector in OTLP/HTTP JSON", func() {
+			requests := make(chan map[string]interface{}, 1)
+			collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer GinkgoRecover()
+				Expect(r.URL.Path).To(Equal("/v1/traces"))
+				Expect(r.Header.Get("Content-Type")).To(Equal("application/json"))
+
+				var body map[string]interface{}
+				Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+				requests <- body
+			}))
+			defer collector.Close()
+
+			exporter := thruster.NewOTLPSpanExporter(collector.URL+"/v1/traces", "users")
+			Expect(exporter.ExportSpans([]thruster.Span{{
+				TraceID:    "4bf92f3577b34da6a3ce929d0e0e4736",
+				SpanID:     "00f067aa0ba902b7",
+				Name:       "GET /users",
+				Error:      true,
+				Attributes: map[string]interface{}{"http.status_code": 500},
+			}})).To(Succeed())
+
+			var body map[string]interface{}
+			Eventually(requests).Should(Receive(&body))
+			resourceSpans := body["resourceSpans"].([]interface{})[0].(map[string]interface{})
+			Expect(resourceSpans["resource"]).To(Equal(map[string]interface{}{
+				"attributes": []interface{}{
+					map[string]interface{}{"key": "service.name", "value": map[string]interface{}{"stringValue": "users"}},
+				},
+			}))
+
+			span := resourceSpans["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})[0].(map[string]interface{})
+			Expect(span["traceId"]).To(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+			Expect(span["name"]).To(Equal("GET /users"))
+			Expect(span["kind"]).To(BeNumerically("==", 2))
+			Expect(span["status"]).To(Equal(map[string]interface{}{"code": float64(2)}))
+			Expect(span["attributes"]).To(ContainElement(map[string]interface{}{
+				"key": "http.status_code", "value": map[string]interface{}{"intValue": "500"},
+			}))
+		})
+
+		It("fails on error responses", func() {
+			collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+			defer collector.Close()
+
+			exporter := thruster.NewOTLPSpanExporter(collector.URL, "users")
+			Expect(exporter.ExportSpans([]thruster.Span{{Name: "GET /"}})).ToNot(Succeed())
+		})
+	})
+})